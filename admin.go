@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// registerAdminHandlers registers the health, metrics, config, pprof and admin API handlers. These are served
+// on the admin listener so they are never exposed through the ingress with the user traffic.
+func registerAdminHandlers(router *http.ServeMux, auth adminAuth) {
+	router.HandleFunc("/healthz", healthz)
+	router.HandleFunc("/readyz", readyz)
+	router.HandleFunc("/metrics", serveMetrics)
+	router.HandleFunc("/config", auth.wrap(getConfig))
+	router.HandleFunc("/debug/pprof/", auth.wrap(pprof.Index))
+	router.HandleFunc("/debug/pprof/cmdline", auth.wrap(pprof.Cmdline))
+	router.HandleFunc("/debug/pprof/profile", auth.wrap(pprof.Profile))
+	router.HandleFunc("/debug/pprof/symbol", auth.wrap(pprof.Symbol))
+	router.HandleFunc("/debug/pprof/trace", auth.wrap(pprof.Trace))
+	router.HandleFunc("/admin/", auth.wrap(http.NotFound))
+	router.HandleFunc("/admin/settings", auth.wrap(adminSettings))
+	router.HandleFunc("/admin/flip", auth.wrap(adminFlip))
+	router.HandleFunc("/admin/abort", auth.wrap(adminAbort))
+	router.HandleFunc("/admin/cpu-burn", auth.wrap(adminCPUBurn))
+	router.HandleFunc("/quitquitquit", quitQuitQuit)
+}
+
+// flipColors are the two colors switched by the flip admin action
+var flipColors = [2]string{"blue", "green"}
+
+// allowAbort enables the abort admin action, which is meant for tests and demos only
+var allowAbort bool
+
+// abortExitCode is the exit code of the aborted process, the one of a process killed by SIGKILL
+const abortExitCode = 137
+
+// healthResponse is the body of the health check responses
+type healthResponse struct {
+	XMLName xml.Name `json:"-" xml:"health"`
+	Status  string   `json:"status" xml:"status"`
+}
+
+func (h healthResponse) String() string {
+	return h.Status
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	server.WriteResponse(w, r, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+type settingsResponse struct {
+	XMLName xml.Name `json:"-" xml:"settings"`
+	settings
+	Generation uint64 `json:"generation" xml:"generation"`
+}
+
+// adminSettings returns the current settings on GET and replaces them on PUT or POST
+func adminSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var newSettings settings
+		// unlike decodeBody, an empty body is rejected instead of resetting the settings
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&newSettings); err != nil {
+			if !bodyTooLarge(w, r, "settings", err) {
+				server.WriteError(w, r, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+		if err := state.set(newSettings); err != nil {
+			server.WriteError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Settings updated: %s", formatSettings(newSettings))
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	current, generation := state.get()
+	server.WriteResponse(w, r, http.StatusOK, settingsResponse{settings: current, Generation: generation})
+}
+
+// adminFlip atomically switches every response between the two flip colors, simulating a blue/green
+// cutover performed inside the application. Any color weights are cleared.
+func adminFlip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	flipped, err := state.update(func(current settings) settings {
+		current.ColorWeights = nil
+		if current.Color == flipColors[0] {
+			current.Color = flipColors[1]
+		} else {
+			current.Color = flipColors[0]
+		}
+		return current
+	})
+	if err != nil {
+		server.WriteError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Flipped color to %s", flipped.Color)
+	current, generation := state.get()
+	server.WriteResponse(w, r, http.StatusOK, settingsResponse{settings: current, Generation: generation})
+}
+
+// adminAbort exits the process immediately, without graceful shutdown, so rollback-on-crash demos
+// can kill specific pods on demand
+func adminAbort(w http.ResponseWriter, r *http.Request) {
+	if !allowAbort {
+		server.WriteError(w, r, http.StatusNotFound, "abort is disabled, set --allow-abort to enable it")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	log.Printf("Aborting on request of %s", r.RemoteAddr)
+	os.Exit(abortExitCode)
+}
+
+func formatSettings(s settings) string {
+	out := fmt.Sprintf("color=%q", s.Color)
+	if s.ErrorRate != nil {
+		out += fmt.Sprintf(" errorRate=%d%%", *s.ErrorRate)
+	}
+	if len(s.ColorErrorRates) > 0 {
+		out += fmt.Sprintf(" colorErrorRates=%v", s.ColorErrorRates)
+	}
+	if s.Latency != nil {
+		out += fmt.Sprintf(" latency=%ds", *s.Latency)
+	}
+	if len(s.ColorWeights) > 0 {
+		out += fmt.Sprintf(" colorWeights=%v", s.ColorWeights)
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+var analysisRequestsTotal = newCounterVec("rollouts_demo_analysis_requests_total",
+	"Total number of requests identified as analysis traffic.", "handler")
+
+// analysisConfig identifies the requests sent by the Argo Rollouts web metric provider, which can
+// be served different faults than the user traffic to demo false positive and false negative
+// analysis scenarios
+type analysisConfig struct {
+	// userAgent is matched as a substring of the User-Agent header
+	userAgent string
+	// header is matched as a name:value request header
+	header *config.Pair
+	// errorRate and latency replace the runtime settings for the analysis requests when set
+	errorRate *int
+	latency   *int
+}
+
+var analysis analysisConfig
+
+// parseAnalysisHeader parses a name:value header match, e.g. X-Analysis:true
+func parseAnalysisHeader(match string) (*config.Pair, error) {
+	if match == "" {
+		return nil, nil
+	}
+	pairs, err := config.ParsePairs(match)
+	if err != nil || len(pairs) != 1 {
+		return nil, fmt.Errorf("invalid analysis header %q, expected <name>:<value>", match)
+	}
+	return &pairs[0], nil
+}
+
+// faultsFromEnv reads the faults of the analysis requests from the ANALYSIS_ERROR_RATE and
+// ANALYSIS_LATENCY environment variables
+func (a *analysisConfig) faultsFromEnv() error {
+	if errorRate := os.Getenv("ANALYSIS_ERROR_RATE"); errorRate != "" {
+		rate, err := strconv.Atoi(errorRate)
+		if err != nil || rate < 0 || rate > 100 {
+			return fmt.Errorf("invalid ANALYSIS_ERROR_RATE value: %s", errorRate)
+		}
+		a.errorRate = &rate
+	}
+	if latency := os.Getenv("ANALYSIS_LATENCY"); latency != "" {
+		seconds, err := strconv.Atoi(latency)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid ANALYSIS_LATENCY value: %s", latency)
+		}
+		a.latency = &seconds
+	}
+	return nil
+}
+
+// matches returns whether the request was sent by the analysis
+func (a analysisConfig) matches(r *http.Request) bool {
+	if a.userAgent != "" && strings.Contains(r.UserAgent(), a.userAgent) {
+		return true
+	}
+	return a.header != nil && r.Header.Get(a.header.Key) == a.header.Value
+}
+
+// apply returns the settings used to serve the analysis requests
+func (a analysisConfig) apply(current settings) settings {
+	if a.errorRate != nil {
+		current.ErrorRate = a.errorRate
+	}
+	if a.latency != nil {
+		current.Latency = a.latency
+	}
+	return current
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var apiKeyRequestsTotal = newCounterVec("rollouts_demo_api_key_requests_total",
+	"Number of color requests checked for an API key, by key name and result: ok, missing or invalid.",
+	"key", "result")
+
+// apiKey is an API key accepted by the color API, along with the name it is reported under
+type apiKey struct {
+	name  string
+	value string
+}
+
+// apiKeys enforces the X-API-Key header on the color API, so authentication failures can be shown as
+// a distinct signal from the injected 5xxs. It is disabled when no keys are loaded.
+type apiKeys []apiKey
+
+// loadAPIKeys reads the API keys from a file of <name>:<key> lines, ignoring the empty lines and the
+// comments starting with #
+func loadAPIKeys(file string) (apiKeys, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys apiKeys
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		split := strings.SplitN(entry, ":", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" || strings.TrimSpace(split[1]) == "" {
+			return nil, fmt.Errorf("%s:%d: invalid entry, expected <name>:<key>", file, line)
+		}
+		keys = append(keys, apiKey{name: strings.TrimSpace(split[0]), value: strings.TrimSpace(split[1])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no API keys", file)
+	}
+	return keys, nil
+}
+
+// lookup returns the name of the given key, comparing it with all the keys in constant time
+func (k apiKeys) lookup(value string) (string, bool) {
+	name, found := "", false
+	for _, key := range k {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(key.value)) == 1 {
+			name, found = key.name, true
+		}
+	}
+	return name, found
+}
+
+// wrap rejects the requests without a valid X-API-Key header with a 401
+func (k apiKeys) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if len(k) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get("X-API-Key")
+		if value == "" {
+			apiKeyRequestsTotal.inc("", "missing")
+			server.WriteError(w, r, http.StatusUnauthorized, "missing API key")
+			return
+		}
+		name, ok := k.lookup(value)
+		if !ok {
+			apiKeyRequestsTotal.inc("", "invalid")
+			server.WriteError(w, r, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		apiKeyRequestsTotal.inc(name, "ok")
+		next(w, r)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/faults"
+	"github.com/argoproj/rollouts-demo/internal/server"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+)
+
+// apmConfig selects the APM provider and configures the transport it reports through
+type apmConfig struct {
+	// mode is the provider: newrelic (or on), otel or off
+	mode string
+	// proxyURL is the proxy the New Relic agent or the OTLP exporter connects through, defaulting to
+	// the HTTPS_PROXY environment variable
+	proxyURL           string
+	caFile             string
+	insecureSkipVerify bool
+	// appName is the New Relic application name, which can contain pod metadata placeholders
+	appName string
+}
+
+// defaultAPMAppName is the New Relic application name used unless configured otherwise
+const defaultAPMAppName = "connect-service-cell-app"
+
+// podMetadataExpander returns a function replacing the {namespace}, {pod}, {role} and {color}
+// placeholders with the metadata of the pod, so each rollout variant can appear as a distinct or
+// decorated APM entity. Unknown values are replaced with "unknown".
+func podMetadataExpander(color string) func(string) string {
+	if color == "" {
+		color = "random"
+	}
+	var pairs []string
+	for placeholder, value := range map[string]string{
+		"{namespace}": identity.podNamespace,
+		"{pod}":       identity.podName,
+		"{role}":      identity.rolloutRole,
+		"{color}":     color,
+	} {
+		if value == "" {
+			value = "unknown"
+		}
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace
+}
+
+// apmProvider traces the requests served and the outbound calls. It traces nothing until the
+// provider selected by the configuration is started.
+var apmProvider = telemetry.Noop()
+
+// providerName returns the APM provider selected by the --apm flag, New Relic being disabled by
+// the NEW_RELIC_ENABLED environment variable
+func (c apmConfig) providerName() (string, error) {
+	switch c.mode {
+	case "newrelic", "on":
+	case "otel", "off":
+		return c.mode, nil
+	default:
+		return "", fmt.Errorf("invalid apm value %q, expected newrelic, otel or off", c.mode)
+	}
+	if enabled := os.Getenv("NEW_RELIC_ENABLED"); enabled != "" {
+		on, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return "", fmt.Errorf("invalid NEW_RELIC_ENABLED value: %s", enabled)
+		}
+		if !on {
+			return "off", nil
+		}
+	}
+	return "newrelic", nil
+}
+
+// transport returns the transport the agent reports through
+func (c apmConfig) transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.proxyURL != "" {
+		proxy, err := url.Parse(c.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid APM proxy URL %q: %v", c.proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if c.caFile != "" || c.insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.insecureSkipVerify}
+	}
+	if c.caFile != "" {
+		pool, err := loadCAFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid APM CA file: %v", err)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return transport, nil
+}
+
+// newAPMProvider starts the configured APM provider, filling in the pod metadata and initial color
+// of the pod in the application name and labels. When APM is disabled the no-op provider is
+// returned, so the demo runs cleanly in clusters without APM or license key.
+func newAPMProvider(c apmConfig, color string) (telemetry.Provider, error) {
+	name, err := c.providerName()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "newrelic":
+		return telemetry.NewRelic(transport, c.appName, podMetadataExpander(color))
+	case "otel":
+		log.Println("Exporting traces with OpenTelemetry")
+		return telemetry.OpenTelemetry(context.Background(), transport)
+	}
+	log.Println("APM disabled")
+	return telemetry.Noop(), nil
+}
+
+// addTransactionAttributes adds the color and fault attributes of a request to its transaction, so
+// APM faceting can split canary and stable performance without log parsing
+func addTransactionAttributes(txn telemetry.Transaction, info *requestInfo) {
+	if info.color != "" {
+		txn.AddAttribute("color", info.color)
+	}
+	txn.AddAttribute("delayMs", float64(info.delay)/float64(time.Millisecond))
+	txn.AddAttribute("injectedError", info.injectedError)
+	txn.AddAttribute("mirrored", info.mirrored)
+	txn.AddAttribute("analysis", info.analysis)
+	if info.subject != "" {
+		txn.AddAttribute("sub", info.subject)
+	}
+	if len(info.groups) > 0 {
+		txn.AddAttribute("groups", strings.Join(info.groups, ","))
+	}
+	if identity.rolloutRole != "" {
+		txn.AddAttribute("rolloutRole", identity.rolloutRole)
+	}
+	if identity.podTemplateHash != "" {
+		txn.AddAttribute("podTemplateHash", identity.podTemplateHash)
+	}
+}
+
+// injectedErrorClass is the class of the errors recorded for the injected failures, telling them
+// apart from real errors in APM
+const injectedErrorClass = "InjectedError"
+
+// errorGroupingNames are the attributes of the recorded errors which can be combined in their
+// errorGroup attribute
+var errorGroupingNames = []string{"faultType", "statusCode", "color", "source"}
+
+// errorGrouping are the attributes combined in the errorGroup attribute of the recorded errors, so
+// APM error analytics can group the injected failures into meaningful buckets
+var errorGrouping = []string{"faultType", "statusCode", "color"}
+
+// parseErrorGrouping parses a comma separated list of error grouping attributes
+func parseErrorGrouping(list string) ([]string, error) {
+	names := config.SplitList(list)
+	for _, name := range names {
+		valid := false
+		for _, validName := range errorGroupingNames {
+			valid = valid || name == validName
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid error grouping attribute %q, expected one of %v", name, errorGroupingNames)
+		}
+	}
+	return names, nil
+}
+
+// noticeInjectedError records an injected failure as an error of the transaction, along with its
+// probability, source and grouping attributes
+func noticeInjectedError(txn telemetry.Transaction, color string, f faults.Faults) {
+	if !f.Fail {
+		return
+	}
+	faultType := "error"
+	if f.Delay > 0 {
+		faultType = "delayed_error"
+	}
+	attributes := map[string]interface{}{
+		"probability": f.ErrorRate,
+		"source":      f.ErrorSource,
+		"faultType":   faultType,
+		"statusCode":  http.StatusInternalServerError,
+	}
+	message := "injected error"
+	if color != "" {
+		attributes["color"] = color
+		message += " serving " + color
+	}
+	if len(errorGrouping) > 0 {
+		group := make([]string, len(errorGrouping))
+		for i, name := range errorGrouping {
+			group[i] = "-"
+			if value, ok := attributes[name]; ok {
+				group[i] = fmt.Sprint(value)
+			}
+		}
+		attributes["errorGroup"] = strings.Join(group, "/")
+	}
+	txn.NoticeError(errors.New(message), injectedErrorClass, attributes)
+}
+
+// tracedSleep sleeps in a segment of the transaction of the context, so transaction traces show the
+// time spent in the injected delays rather than a flat handler duration. It returns early with an
+// error when the context is done, e.g. the client went away, or with server.ErrDraining when the
+// servers start draining.
+func tracedSleep(ctx context.Context, name string, d time.Duration) error {
+	defer telemetry.FromContext(ctx).StartSegment(name).End()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-drainStarted:
+		return server.ErrDraining
+	}
+}
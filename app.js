@@ -133,6 +133,67 @@ class Chart {
     }
 }
 
+// applyTheme colors the UI after the color served by the pod which served the page, and shows its
+// version in the banner, so screenshots immediately tell which variant is being viewed
+function applyTheme(banner) {
+    const meta = (name) => {
+        const element = document.querySelector('meta[name="' + name + '"]');
+        return element ? element.content : "";
+    };
+    const color = meta("rollouts-demo-color") || "random";
+    const version = meta("rollouts-demo-version");
+    if (color != "random") {
+        document.documentElement.style.setProperty("--served-color", color);
+    }
+    banner.innerText = capitalize(color) + (version ? " \u2022 " + version : "");
+}
+
+// Number of recent responses the pod distribution is computed from
+let PodsWindowSize=200
+
+// Pods renders which pods served the recent responses, from their identity headers, so viewers can
+// watch the traffic shift between the stable and canary ReplicaSets during a rollout
+class Pods {
+    constructor(table) {
+        this.table = table;
+        this.recent = [];
+    }
+
+    add(headers) {
+        this.recent.push({
+            name: headers.get("X-Pod-Name") || "unknown",
+            hash: headers.get("X-Pod-Template-Hash") || "",
+            role: headers.get("X-Rollout-Role") || "",
+        });
+        if (this.recent.length > PodsWindowSize) {
+            this.recent.shift();
+        }
+        this.render();
+    }
+
+    render() {
+        const counts = new Map();
+        this.recent.forEach((pod) => {
+            const entry = counts.get(pod.name) || {pod, count: 0};
+            entry.count++;
+            counts.set(pod.name, entry);
+        });
+        const rows = Array.from(counts.values()).sort(function(first, second) {
+            return first.pod.role.localeCompare(second.pod.role) || first.pod.name.localeCompare(second.pod.name);
+        });
+        this.table.replaceChildren(...rows.map(({pod, count}) => {
+            const row = document.createElement("tr");
+            row.className = pod.role;
+            [pod.name, pod.hash, pod.role, Math.round(100 * count / this.recent.length) + "%"].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.innerText = value;
+                row.appendChild(cell);
+            });
+            return row;
+        }));
+    }
+}
+
 let ParticleMaxSize=30
 let ArgoImageSize=250
 
@@ -142,6 +203,8 @@ export class App {
         this.particles = [];
         this.chart = new Chart(this, canvas);
         this.sliders = new Sliders(this)
+        this.pods = new Pods(document.getElementById("pods"))
+        applyTheme(document.getElementById("banner"))
     }
 
 
@@ -163,6 +226,7 @@ export class App {
             this.particles = this.particles.slice(0, 200);
             this.chart.addColor(res.color, res.res.status);
             this.sliders.addColor(res.color)
+            this.pods.add(res.res.headers)
         }).bind(this));
     }
 
@@ -248,6 +312,10 @@ export class Sliders {
         this.delayPercentText = document.getElementById("delayPercentText");
         this.delayPercent.addEventListener("input", this.updateColor.bind(this))
         
+        // the outputs follow the sliders, which the Content-Security-Policy forbids doing inline
+        this.return500.addEventListener("change", () => this.return500Text.value = this.return500.value + "%")
+        this.delayPercent.addEventListener("change", () => this.delayPercentText.value = this.delayPercent.value + "%")
+
         this.delayLength = document.getElementById("delayLength");
         this.delayLength.addEventListener("input", this.updateColor.bind(this))
 
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// uiAssets are the files referenced by the UI pages, which are served under content hashed names so
+// the browsers can cache them forever yet load the new ones as soon as a new version is rolled out
+var uiAssets = []string{"index.js", "app.js", "dashboard-main.js", "dashboard.js", "main.css", "favicon.ico", "logo.png"}
+
+// immutableCacheControl is the Cache-Control header of the assets requested by their hashed name
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// assetRef matches the quoted relative references of the pages, e.g. "main.css" or './app.js'
+var assetRef = regexp.MustCompile(`(["'])(\./)?([A-Za-z0-9_-]+\.[A-Za-z0-9]+)(["'])`)
+
+// assetManifest holds the content hash of the UI assets, keyed by file name
+type assetManifest map[string]string
+
+// buildManifest hashes the UI assets of the directory, skipping the missing ones
+func buildManifest(dir http.FileSystem) assetManifest {
+	manifest := make(assetManifest)
+	for _, name := range uiAssets {
+		f, err := dir.Open("/" + name)
+		if err != nil {
+			continue
+		}
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Could not hash %s: %v", name, err)
+			continue
+		}
+		sum := sha256.Sum256(content)
+		manifest[name] = hex.EncodeToString(sum[:])[:12]
+	}
+	return manifest
+}
+
+// hashedName returns the name of the asset including its content hash, e.g. app.0123456789ab.js
+func (m assetManifest) hashedName(name string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + m[name] + ext
+}
+
+// resolve returns the asset requested by its hashed name, and whether the hash is the one of the
+// served content. Requests for another version of the asset, e.g. from a page served by a pod on the
+// other side of the rollout, get the current content which must then not be cached forever.
+func (m assetManifest) resolve(urlPath string) (name string, current bool, ok bool) {
+	base := path.Base(urlPath)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	dot := strings.LastIndexByte(stem, '.')
+	if dot < 0 {
+		return "", false, false
+	}
+	name = stem[:dot] + ext
+	hash, ok := m[name]
+	if !ok || path.Dir(urlPath) != "/" {
+		return "", false, false
+	}
+	return name, stem[dot+1:] == hash, true
+}
+
+// rewrite replaces the references to the assets in the page with their hashed names
+func (m assetManifest) rewrite(page []byte) []byte {
+	return assetRef.ReplaceAllFunc(page, func(ref []byte) []byte {
+		groups := assetRef.FindSubmatch(ref)
+		name := string(groups[3])
+		if _, ok := m[name]; !ok || groups[1][0] != groups[4][0] {
+			return ref
+		}
+		return []byte(string(groups[1]) + "./" + m.hashedName(name) + string(groups[4]))
+	})
+}
+
+// serveAsset serves a UI file with the caching headers: forever for the hashed names of the current
+// version, and with revalidation against the content hash ETag otherwise
+func serveAsset(w http.ResponseWriter, r *http.Request, manifest assetManifest, files http.Handler) {
+	cacheControl := "no-cache"
+	if name, current, ok := manifest.resolve(r.URL.Path); ok {
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + name
+		if current {
+			cacheControl = immutableCacheControl
+		}
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	if hash, ok := manifest[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+		// the file server answers the conditional requests against it
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+	files.ServeHTTP(w, r)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/xml"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var assignmentsTotal = newCounterVec("rollouts_demo_assignments_total",
+	"Total number of experiment assignments.", "variant")
+
+// experimentConfig configures the A/B experiment served by /assign
+type experimentConfig struct {
+	// name salts the hash, so different experiments bucket the same users differently
+	name string
+	// variants are the weighted variants the users are assigned to, e.g. {"blue": 50, "green": 50}
+	variants map[string]int
+}
+
+var experiment = experimentConfig{
+	name:     "rollouts-demo",
+	variants: map[string]int{"blue": 50, "green": 50},
+}
+
+// assignmentResponse is the body of the /assign responses
+type assignmentResponse struct {
+	XMLName    xml.Name `json:"-" xml:"assignment"`
+	Experiment string   `json:"experiment" xml:"experiment"`
+	UserID     string   `json:"userId" xml:"userId"`
+	Variant    string   `json:"variant" xml:"variant"`
+	Bucket     int      `json:"bucket" xml:"bucket"`
+}
+
+func (a assignmentResponse) String() string {
+	return a.Variant
+}
+
+// hashBucket deterministically maps a key to a bucket between 0 and n-1
+func hashBucket(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// userID returns the id of the user sent in the X-User-Id header or the user query parameter
+func userID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get("X-User-Id")); id != "" {
+		return id
+	}
+	return strings.TrimSpace(r.URL.Query().Get("user"))
+}
+
+// assign deterministically assigns the user to one of the experiment variants by hashing its id,
+// illustrating experiment bucketing alongside rollouts. The same user always gets the same variant
+// as long as the variants and weights are unchanged.
+func assign(w http.ResponseWriter, r *http.Request) {
+	id := userID(r)
+	if id == "" {
+		server.WriteError(w, r, http.StatusBadRequest, "missing X-User-Id header or user query parameter")
+		return
+	}
+	total := 0
+	for _, weight := range experiment.variants {
+		total += weight
+	}
+	bucket := hashBucket(experiment.name+":"+id, total)
+	variant := weightedPick(experiment.variants, bucket)
+	requestInfoFrom(w, r).color = variant
+	assignmentsTotal.inc(variant)
+	server.WriteResponse(w, r, http.StatusOK, assignmentResponse{
+		Experiment: experiment.name,
+		UserID:     id,
+		Variant:    variant,
+		Bucket:     bucket,
+	})
+}
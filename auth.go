@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+const (
+	// uiSessionCookie keeps the signed session of the users logged in with OIDC
+	uiSessionCookie = "rollouts-demo-session"
+	// oidcStateCookie keeps the signed state of the login in progress, along with the page to return to
+	oidcStateCookie = "rollouts-demo-oidc-state"
+	// oidcStateMaxAge bounds the time users have to log in at the identity provider
+	oidcStateMaxAge = 10 * time.Minute
+	// oidcTimeout bounds the requests to the identity provider
+	oidcTimeout = 10 * time.Second
+)
+
+// uiAuth gates the UI and the fault injection controls behind a login, so shared demo clusters don't
+// expose them publicly. The users log in with basic auth or with an OpenID Connect provider, and the
+// gate is disabled when no mode is configured.
+type uiAuth struct {
+	mode     string
+	username string
+	password string
+	oidc     *oidcProvider
+}
+
+// uiAuthFromEnv reads the credentials of the given mode from the environment: UI_AUTH_USERNAME and
+// UI_AUTH_PASSWORD for basic, OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL
+// and UI_AUTH_SECRET for oidc
+func uiAuthFromEnv(mode string) (uiAuth, error) {
+	a := uiAuth{mode: mode}
+	switch mode {
+	case "", "none":
+		a.mode = ""
+	case "basic":
+		a.username, a.password = os.Getenv("UI_AUTH_USERNAME"), os.Getenv("UI_AUTH_PASSWORD")
+		if a.username == "" || a.password == "" {
+			return a, fmt.Errorf("basic UI authentication requires UI_AUTH_USERNAME and UI_AUTH_PASSWORD")
+		}
+	case "oidc":
+		p := &oidcProvider{
+			issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/"),
+			clientID:     os.Getenv("OIDC_CLIENT_ID"),
+			clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			secret:       []byte(os.Getenv("UI_AUTH_SECRET")),
+			client:       &http.Client{Timeout: oidcTimeout},
+		}
+		if p.issuer == "" || p.clientID == "" || p.redirectURL == "" || len(p.secret) == 0 {
+			return a, fmt.Errorf("OIDC UI authentication requires OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_REDIRECT_URL and UI_AUTH_SECRET")
+		}
+		u, err := url.Parse(p.redirectURL)
+		if err != nil || !u.IsAbs() {
+			return a, fmt.Errorf("invalid OIDC_REDIRECT_URL value: %s", p.redirectURL)
+		}
+		// the callback is served on the UI router, where the root is the UI itself
+		if u.Path == "" || u.Path == "/" {
+			return a, fmt.Errorf("invalid OIDC_REDIRECT_URL value %s: its path is the login callback, e.g. /oauth2/callback, and can't be the root", p.redirectURL)
+		}
+		p.callbackPath = u.Path
+		p.secure = u.Scheme == "https"
+		a.oidc = p
+	default:
+		return a, fmt.Errorf("invalid UI authentication mode %s, expected none, basic or oidc", mode)
+	}
+	return a, nil
+}
+
+// wrap lets the authenticated requests through to the handler and rejects the others, redirecting
+// the browser navigations to the identity provider in oidc mode
+func (a uiAuth) wrap(next http.HandlerFunc) http.HandlerFunc {
+	switch a.mode {
+	case "basic":
+		return requireBasicAuth("rollouts-demo", a.username, a.password, next)
+	case "oidc":
+		return func(w http.ResponseWriter, r *http.Request) {
+			if a.oidc.session(r) {
+				next(w, r)
+				return
+			}
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				a.oidc.login(w, r)
+				return
+			}
+			server.WriteError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+	}
+	return next
+}
+
+// requireBasicAuth lets the requests with the given basic auth credentials through to the handler
+// and rejects the others
+func requireBasicAuth(realm, username, password string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+			server.WriteError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminAuth protects the admin endpoints changing the behavior of the application or exposing its
+// internals with basic auth, so the fault controls can't be abused in shared environments. The
+// probes and the metrics stay open. It is disabled when no credentials are configured.
+type adminAuth struct {
+	username string
+	password string
+}
+
+// adminAuthFromEnv reads the admin credentials from ADMIN_USERNAME and ADMIN_PASSWORD, which can be
+// given in files like the other secrets, e.g. with ADMIN_PASSWORD_FILE
+func adminAuthFromEnv() (adminAuth, error) {
+	a := adminAuth{username: os.Getenv("ADMIN_USERNAME"), password: os.Getenv("ADMIN_PASSWORD")}
+	if (a.username == "") != (a.password == "") {
+		return a, fmt.Errorf("admin authentication requires both ADMIN_USERNAME and ADMIN_PASSWORD or ADMIN_PASSWORD_FILE")
+	}
+	return a, nil
+}
+
+func (a adminAuth) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if a.username == "" {
+		return next
+	}
+	return requireBasicAuth("rollouts-demo admin", a.username, a.password, next)
+}
+
+// oidcProvider logs the users in with the authorization code flow of an OpenID Connect provider,
+// keeping their session in a cookie signed with the secret shared by the replicas, so the users stay
+// logged in whichever pod serves them
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	callbackPath string
+	secure       bool
+	secret       []byte
+	client       *http.Client
+
+	mu        sync.Mutex
+	endpoints *oidcEndpoints
+}
+
+// oidcEndpoints are the endpoints of the provider, from its discovery document
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// discover returns the endpoints of the provider, fetching its discovery document on first use so
+// the pods start even when the provider is unavailable
+func (p *oidcProvider) discover() (*oidcEndpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+	resp, err := p.client.Get(p.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery document returned %d", resp.StatusCode)
+	}
+	var endpoints oidcEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, err
+	}
+	if endpoints.AuthorizationEndpoint == "" || endpoints.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document lacks the authorization or token endpoint")
+	}
+	p.endpoints = &endpoints
+	return p.endpoints, nil
+}
+
+// sign returns the value followed by its signature
+func (p *oidcProvider) sign(value string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the value of a signed value, and whether the signature is valid
+func (p *oidcProvider) verify(signed string) (string, bool) {
+	dot := strings.LastIndexByte(signed, '.')
+	if dot < 0 {
+		return "", false
+	}
+	value := signed[:dot]
+	return value, hmac.Equal([]byte(p.sign(value)), []byte(signed))
+}
+
+// uiSession is the content of the session cookie
+type uiSession struct {
+	Subject string `json:"sub"`
+	Expiry  int64  `json:"exp"`
+}
+
+// session returns whether the request carries a valid session
+func (p *oidcProvider) session(r *http.Request) bool {
+	cookie, err := r.Cookie(uiSessionCookie)
+	if err != nil {
+		return false
+	}
+	value, ok := p.verify(cookie.Value)
+	if !ok {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return false
+	}
+	var s uiSession
+	return json.Unmarshal(data, &s) == nil && time.Now().Unix() < s.Expiry
+}
+
+// login redirects the browser to the authorization endpoint of the provider, remembering the page
+// to return to once logged in
+func (p *oidcProvider) login(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := p.discover()
+	if err != nil {
+		log.Printf("Could not discover the OIDC endpoints: %v", err)
+		server.WriteError(w, r, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		server.WriteError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(nonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    p.sign(state + "." + base64.RawURLEncoding.EncodeToString([]byte(r.URL.RequestURI()))),
+		Path:     p.callbackPath,
+		MaxAge:   int(oidcStateMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+	}
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, endpoints.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// callback completes the login: it exchanges the authorization code for an ID token, starts the
+// session and redirects the browser to the page it was on
+func (p *oidcProvider) callback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		server.WriteError(w, r, http.StatusBadRequest, "no login in progress")
+		return
+	}
+	value, ok := p.verify(cookie.Value)
+	parts := strings.SplitN(value, ".", 2)
+	if !ok || len(parts) != 2 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(r.URL.Query().Get("state"))) != 1 {
+		server.WriteError(w, r, http.StatusBadRequest, "invalid login state")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		server.WriteError(w, r, http.StatusForbidden, "login failed: "+e)
+		return
+	}
+	claims, err := p.exchange(r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("Could not complete the OIDC login: %v", err)
+		server.WriteError(w, r, http.StatusForbidden, "login failed")
+		return
+	}
+	data, _ := json.Marshal(uiSession{Subject: claims.Subject, Expiry: claims.Expiry})
+	http.SetCookie(w, &http.Cookie{
+		Name:     uiSessionCookie,
+		Value:    p.sign(base64.RawURLEncoding.EncodeToString(data)),
+		Path:     "/",
+		Expires:  time.Unix(claims.Expiry, 0),
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: p.callbackPath, MaxAge: -1})
+	log.Printf("User %s logged in to the UI", claims.Subject)
+
+	target := "/"
+	if returnTo, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil &&
+		strings.HasPrefix(string(returnTo), "/") && !strings.HasPrefix(string(returnTo), "//") {
+		target = string(returnTo)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// idTokenClaims are the claims of the ID token checked to start a session
+type idTokenClaims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Expiry   int64           `json:"exp"`
+}
+
+// exchange redeems the authorization code at the token endpoint and returns the claims of the ID
+// token. The ID token is received directly from the provider over TLS, which the OpenID Connect core
+// specification accepts in place of the signature validation for confidential clients.
+func (p *oidcProvider) exchange(code string) (*idTokenClaims, error) {
+	endpoints, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.redirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token: %v", err)
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token: %v", err)
+	}
+	if claims.Issuer != endpoints.Issuer && claims.Issuer != p.issuer {
+		return nil, fmt.Errorf("ID token issued by %s", claims.Issuer)
+	}
+	if !audienceContains(claims.Audience, p.clientID) {
+		return nil, fmt.Errorf("ID token not issued for %s", p.clientID)
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, fmt.Errorf("expired ID token")
+	}
+	return &claims, nil
+}
+
+// audienceContains returns whether the aud claim, a string or an array of strings, contains the
+// client id
+func audienceContains(aud json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == clientID
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) != nil {
+		return false
+	}
+	for _, a := range list {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
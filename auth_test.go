@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// setEnv sets the environment variables until the returned function restores them
+func setEnv(vars map[string]string) func() {
+	previous := make(map[string]*string, len(vars))
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestUIAuthFromEnvOIDCRedirectURL(t *testing.T) {
+	tests := []struct {
+		redirectURL string
+		wantErr     bool
+	}{
+		{redirectURL: "https://demo.example.com/oauth2/callback"},
+		{redirectURL: "http://localhost:8080/callback"},
+		{redirectURL: "https://demo.example.com", wantErr: true},
+		{redirectURL: "https://demo.example.com/", wantErr: true},
+		{redirectURL: "/oauth2/callback", wantErr: true},
+	}
+	for _, tt := range tests {
+		restore := setEnv(map[string]string{
+			"OIDC_ISSUER_URL":    "https://issuer.example.com",
+			"OIDC_CLIENT_ID":     "rollouts-demo",
+			"OIDC_CLIENT_SECRET": "secret",
+			"OIDC_REDIRECT_URL":  tt.redirectURL,
+			"UI_AUTH_SECRET":     "signing-secret",
+		})
+		a, err := uiAuthFromEnv("oidc")
+		restore()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("uiAuthFromEnv with OIDC_REDIRECT_URL=%s error = %v, wantErr %v", tt.redirectURL, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (a.oidc.callbackPath == "" || a.oidc.callbackPath == "/") {
+			t.Errorf("uiAuthFromEnv with OIDC_REDIRECT_URL=%s serves the callback on %q", tt.redirectURL, a.oidc.callbackPath)
+		}
+	}
+}
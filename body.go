@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var oversizedRequestsTotal = newCounterVec("rollouts_demo_oversized_requests_total",
+	"Number of requests rejected with a 413 for a body exceeding the maximum body size.",
+	"handler")
+
+// maxBodySize limits the size of the request bodies read in memory
+var maxBodySize int64 = 1 << 20
+
+// readBody reads the body of the request, replying with a 413 when it exceeds the maximum body size
+// instead of risking the memory exhaustion of the pod, or with a 500 when it can't be read
+func readBody(w http.ResponseWriter, r *http.Request, handler string) ([]byte, bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		if !bodyTooLarge(w, r, handler, err) {
+			log.Println(err.Error())
+			server.WriteError(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeBody decodes the JSON body of the request into v as it is read, instead of buffering it whole
+// first. An empty body leaves v untouched. It replies with a 413 when the body exceeds the maximum body
+// size, and with the given status when it can't be read or isn't a single JSON value.
+func decodeBody(w http.ResponseWriter, r *http.Request, handler string, v interface{}, invalidStatus int) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	err := decoder.Decode(v)
+	if err == nil {
+		// rejects the trailing data, as json.Unmarshal does
+		if _, err = decoder.Token(); err == nil {
+			err = fmt.Errorf("invalid data after the JSON value")
+		}
+	}
+	if err != nil && err != io.EOF {
+		if !bodyTooLarge(w, r, handler, err) {
+			log.Printf("Invalid %s body: %v", handler, err)
+			server.WriteError(w, r, invalidStatus, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// bodyTooLarge replies with a 413 when the error is the one of a body exceeding the maximum body size
+func bodyTooLarge(w http.ResponseWriter, r *http.Request, handler string, err error) bool {
+	// the error of the MaxBytesReader is only typed from Go 1.19 on
+	if err.Error() != "http: request body too large" {
+		return false
+	}
+	oversizedRequestsTotal.inc(handler)
+	server.WriteError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds the maximum body size %d", maxBodySize))
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+)
+
+// uiFiles are the files of the UI embedded in the binary, so it can run from any directory without
+// exposing the other files of the working directory
+//
+//go:embed index.html index.js app.js main.css favicon.ico logo.png dashboard.html dashboard.js dashboard-main.js
+var uiFiles embed.FS
+
+// serveUI serves the files of the UI, injecting the browser monitoring snippets at the top of the
+// head of the HTML pages, so the front-end performance of the demo UI is captured alongside the
+// backend traces. The browser agent of the APM provider is injected, if any, followed by the custom
+// snippet (e.g. an OpenTelemetry web instrumentation).
+func serveUI(dir http.FileSystem, customSnippet []byte) http.HandlerFunc {
+	files := http.FileServer(dir)
+	manifest := buildManifest(dir)
+	// the files of a static directory are edited while developing the UI
+	_, live := dir.(http.Dir)
+	currentManifest := func() assetManifest {
+		if live {
+			return buildManifest(dir)
+		}
+		return manifest
+	}
+	page := apmProvider.WrapHandler("/", func(w http.ResponseWriter, r *http.Request) {
+		servePage(w, r, dir, files, currentManifest(), customSnippet)
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if routedPage(r, dir) {
+			// the relative references of the index page must resolve from the root of the UI
+			base := strings.Repeat("../", strings.Count(r.URL.Path, "/")-1)
+			if base == "" {
+				base = "./"
+			}
+			r = r.Clone(context.WithValue(r.Context(), baseHrefKey{}, base))
+			r.URL.Path = "/"
+		}
+		if strings.HasSuffix(r.URL.Path, "/") || strings.HasSuffix(r.URL.Path, ".html") {
+			page(w, r)
+			return
+		}
+		serveAsset(w, r, currentManifest(), files)
+	}
+}
+
+type baseHrefKey struct{}
+
+// routedPage returns whether the request is a browser navigation to a missing page without
+// extension, e.g. a page routed on the client side, which then gets the index page. The API clients
+// don't ask for HTML and keep getting 404s for the unknown paths.
+func routedPage(r *http.Request, dir http.FileSystem) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if strings.HasSuffix(r.URL.Path, "/") || path.Ext(r.URL.Path) != "" {
+		return false
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	f, err := dir.Open(r.URL.Path)
+	if err != nil {
+		return true
+	}
+	f.Close()
+	return false
+}
+
+// servePage serves an HTML page with the browser monitoring snippets injected and the assets
+// referenced by their hashed names, leaving the missing pages to the file server
+func servePage(w http.ResponseWriter, r *http.Request, dir http.FileSystem, files http.Handler, manifest assetManifest, customSnippet []byte) {
+	name := r.URL.Path
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	f, err := dir.Open(name)
+	if err != nil {
+		files.ServeHTTP(w, r)
+		return
+	}
+	page, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	snippet, err := telemetry.FromContext(r.Context()).BrowserTimingHeader()
+	if err != nil {
+		log.Printf("Could not build the browser monitoring snippet: %v", err)
+	}
+	snippet = append(snippet, customSnippet...)
+	snippet = append(pageMetadata(), snippet...)
+	if base, ok := r.Context().Value(baseHrefKey{}).(string); ok {
+		snippet = append([]byte(`<base href="`+base+`">`), snippet...)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the snippet is specific to the transaction serving the page
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write(injectSnippet(manifest.rewrite(page), snippet)); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// pageMetadata describes the variant serving the page, which the UI shows in its banner so the
+// screenshots tell which side of the rollout is being viewed
+func pageMetadata() []byte {
+	current, _ := state.get()
+	color := current.Color
+	if color == "" {
+		color = "random"
+	}
+	v := version
+	if identity.podTemplateHash != "" {
+		v += " (" + identity.podTemplateHash + ")"
+	}
+	return []byte(`<meta name="rollouts-demo-color" content="` + html.EscapeString(color) + `">` +
+		`<meta name="rollouts-demo-version" content="` + html.EscapeString(v) + `">`)
+}
+
+// injectSnippet inserts the snippet right after the head opening tag of the page, or at its top if
+// it has no head
+func injectSnippet(page, snippet []byte) []byte {
+	at := 0
+	if head := bytes.Index(bytes.ToLower(page), []byte("<head")); head >= 0 {
+		if end := bytes.IndexByte(page[head:], '>'); end >= 0 {
+			at = head + end + 1
+		}
+	}
+	out := make([]byte, 0, len(page)+len(snippet))
+	out = append(out, page[:at]...)
+	out = append(out, snippet...)
+	return append(out, page[at:]...)
+}
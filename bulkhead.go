@@ -0,0 +1,52 @@
+package main
+
+var (
+	bulkheadCapacity = newGaugeVec("rollouts_demo_bulkhead_capacity",
+		"Maximum number of concurrent requests of the upstream bulkheads.", "upstream")
+	bulkheadInflight = newGaugeVec("rollouts_demo_bulkhead_inflight_requests",
+		"Number of requests in flight in the upstream bulkheads.", "upstream")
+	bulkheadRejectedTotal = newCounterVec("rollouts_demo_bulkhead_rejected_total",
+		"Total number of upstream requests rejected because their bulkhead was full.", "upstream")
+)
+
+// upstreamMaxConcurrency is the default size of the upstream bulkheads, 0 leaving the upstreams
+// unbounded
+var upstreamMaxConcurrency int
+
+// bulkhead bounds the number of concurrent requests to an upstream, so a slow dependency can't
+// consume all the handler capacity. A nil bulkhead is unbounded.
+type bulkhead struct {
+	name  string
+	slots chan struct{}
+}
+
+func newBulkhead(name string, size int) *bulkhead {
+	if size <= 0 {
+		return nil
+	}
+	bulkheadCapacity.set(float64(size), name)
+	return &bulkhead{name: name, slots: make(chan struct{}, size)}
+}
+
+// acquire takes a slot of the bulkhead, returning false if it is full
+func (b *bulkhead) acquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		bulkheadInflight.inc(b.name)
+		return true
+	default:
+		bulkheadRejectedTotal.inc(b.name)
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	bulkheadInflight.add(-1, b.name)
+	<-b.slots
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// canaryConfig assigns a percentage of the users to the canary color. Users are identified by the
+// X-User-Id header and assigned by hashing their id, so the same user always gets the same
+// experience during a progressive rollout.
+type canaryConfig struct {
+	color   string
+	percent int
+}
+
+var canary canaryConfig
+
+// canaryFromEnv reads the canary assignment from the CANARY_COLOR and CANARY_PERCENT environment
+// variables
+func canaryFromEnv() (canaryConfig, error) {
+	c := canaryConfig{color: os.Getenv("CANARY_COLOR")}
+	percent := os.Getenv("CANARY_PERCENT")
+	if c.color == "" {
+		if percent != "" {
+			return c, fmt.Errorf("CANARY_PERCENT requires CANARY_COLOR")
+		}
+		return c, nil
+	}
+	if !validColorName(c.color) {
+		return c, fmt.Errorf("invalid CANARY_COLOR value: %s", c.color)
+	}
+	if percent != "" {
+		var err error
+		if c.percent, err = strconv.Atoi(percent); err != nil || c.percent < 0 || c.percent > 100 {
+			return c, fmt.Errorf("invalid CANARY_PERCENT value, expected 0 to 100: %s", percent)
+		}
+	}
+	return c, nil
+}
+
+// canaryColor returns the canary color if the user of the request is assigned to the canary. The
+// assignment is reported in the X-Canary header.
+func canaryColor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if canary.color == "" {
+		return "", false
+	}
+	id := r.Header.Get("X-User-Id")
+	if id == "" {
+		return "", false
+	}
+	if hashBucket("canary:"+id, 100) >= canary.percent {
+		w.Header().Set("X-Canary", "false")
+		return "", false
+	}
+	w.Header().Set("X-Canary", "true")
+	return canary.color, true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ansiColors are the terminal escape codes used to render the known colors
+var ansiColors = map[string]string{
+	"red":    "\033[31m",
+	"orange": "\033[38;5;208m",
+	"yellow": "\033[33m",
+	"green":  "\033[32m",
+	"blue":   "\033[34m",
+	"purple": "\033[35m",
+}
+
+const (
+	ansiReset = "\033[0m"
+	// barWidth is the width of the bar of a color representing 100% of the requests
+	barWidth = 50
+)
+
+// clientResult is the outcome of a request sent by the client
+type clientResult struct {
+	color   string
+	status  int
+	latency time.Duration
+}
+
+// clientStats keeps the results of the last requests sent by the client
+type clientStats struct {
+	mu      sync.Mutex
+	results []clientResult
+	next    int
+	total   int
+}
+
+func newClientStats(window int) *clientStats {
+	return &clientStats{results: make([]clientResult, 0, window)}
+}
+
+func (s *clientStats) record(result clientResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	if len(s.results) < cap(s.results) {
+		s.results = append(s.results, result)
+		return
+	}
+	s.results[s.next] = result
+	s.next = (s.next + 1) % len(s.results)
+}
+
+// render writes the color distribution, error rate and latency of the last requests
+func (s *clientStats) render(w io.Writer, target string, rate float64) {
+	s.mu.Lock()
+	results := append([]clientResult(nil), s.results...)
+	total := s.total
+	s.mu.Unlock()
+
+	counts := make(map[string]int)
+	errors := 0
+	latencies := make([]time.Duration, 0, len(results))
+	for _, result := range results {
+		color := result.color
+		if color == "" {
+			color = "(none)"
+		}
+		counts[color]++
+		if result.status == 0 || result.status >= http.StatusInternalServerError {
+			errors++
+		}
+		latencies = append(latencies, result.latency)
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Fprint(w, "\033[H\033[2J")
+	fmt.Fprintf(w, "%s - %.1f requests/s, %d requests sent, last %d shown\n\n", target, rate, total, len(results))
+	for _, name := range names {
+		share := float64(counts[name]) / float64(len(results))
+		width := int(share * barWidth)
+		bar := strings.Repeat("█", width) + strings.Repeat(" ", barWidth-width)
+		fmt.Fprintf(w, "%-10s %s%s%s %5.1f%%\n", name, ansiColors[name], bar, ansiReset, share*100)
+	}
+	if len(results) > 0 {
+		fmt.Fprintf(w, "\nerror rate %5.1f%%   p50 %v   p99 %v\n", float64(errors)*100/float64(len(results)),
+			latencies[len(latencies)/2].Round(time.Millisecond), latencies[len(latencies)*99/100].Round(time.Millisecond))
+	}
+}
+
+// pollColor sends a color request and returns its outcome
+func pollColor(client *http.Client, target string) clientResult {
+	start := time.Now()
+	resp, err := client.Get(target)
+	if err != nil {
+		return clientResult{latency: time.Since(start)}
+	}
+	defer resp.Body.Close()
+	result := clientResult{status: resp.StatusCode}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUpstreamBody))
+	result.latency = time.Since(start)
+	if err == nil {
+		_ = json.Unmarshal(body, &result.color)
+	}
+	return result
+}
+
+// runClient runs the client subcommand, which continuously polls /color and renders the color
+// distribution and error rate in the terminal, for presenters without the browser UI. The request
+// rate follows a load profile, to evaluate how the analysis handles varying traffic.
+func runClient(args []string) {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	target := flags.String("url", "http://localhost:8080/color", "URL of the color endpoint to poll")
+	rps := flags.Float64("rps", 10, "number of requests sent per second, the base rate of the load profile")
+	peakRPS := flags.Float64("peak-rps", 0, "peak number of requests sent per second by the load profile (defaults to 5 times the rate)")
+	profileName := flags.String("profile", "constant", fmt.Sprintf("load profile varying the rate between the base and peak rates, one of %v", loadProfileNames))
+	period := flags.Duration("period", time.Minute, "period of the load profile")
+	window := flags.Int("window", 200, "number of most recent requests the stats are computed on")
+	refresh := flags.Duration("refresh", 500*time.Millisecond, "interval between display refreshes")
+	maxInflight := flags.Int("max-inflight", 100, "maximum number of requests in flight")
+	_ = flags.Parse(args)
+	if *rps <= 0 || *peakRPS < 0 || *period <= 0 || *window <= 0 || *refresh <= 0 || *maxInflight <= 0 {
+		log.Fatal("rps, period, window, refresh and max-inflight must be positive")
+	}
+	if *peakRPS == 0 {
+		*peakRPS = 5 * *rps
+	}
+	profile, err := newLoadProfile(*profileName, *rps, *peakRPS, *period)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	stats := newClientStats(*window)
+	inflight := make(chan struct{}, *maxInflight)
+	start := time.Now()
+	go func() {
+		for {
+			time.Sleep(time.Duration(float64(time.Second) / profile(time.Since(start))))
+			select {
+			case inflight <- struct{}{}:
+			default:
+				continue
+			}
+			go func() {
+				defer func() { <-inflight }()
+				stats.record(pollColor(client, *target))
+			}()
+		}
+	}()
+
+	for range time.Tick(*refresh) {
+		stats.render(os.Stdout, *target, profile(time.Since(start)))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var clientFaultsTotal = newCounterVec("rollouts_demo_client_faults_total",
+	"Total number of faults injected in the outbound requests.", "fault")
+
+// clientFaults are the faults injected in the outbound requests to the upstreams, and not in the
+// local handlers, so traces tell a slow dependency apart from a slow service
+var clientFaults settings
+
+// errClientFault is returned by the outbound requests failed by the client faults
+var errClientFault = errors.New("injected client fault")
+
+// clientFaultsFromEnv reads the faults of the outbound requests from the CLIENT_ERROR_RATE and
+// CLIENT_LATENCY environment variables
+func clientFaultsFromEnv() (settings, error) {
+	var s settings
+	if errorRate := os.Getenv("CLIENT_ERROR_RATE"); errorRate != "" {
+		rate, err := strconv.Atoi(errorRate)
+		if err != nil || rate < 0 || rate > 100 {
+			return s, fmt.Errorf("invalid CLIENT_ERROR_RATE value: %s", errorRate)
+		}
+		s.ErrorRate = &rate
+	}
+	if latency := os.Getenv("CLIENT_LATENCY"); latency != "" {
+		seconds, err := strconv.Atoi(latency)
+		if err != nil || seconds < 0 {
+			return s, fmt.Errorf("invalid CLIENT_LATENCY value: %s", latency)
+		}
+		s.Latency = &seconds
+	}
+	return s, nil
+}
+
+// injectClientFaults delays an outbound request and returns an error if it must fail. It is called
+// once the request is traced, so the delay shows in the outbound span.
+func injectClientFaults(ctx context.Context) error {
+	f := decideFaults(clientFaults, server.ColorParameters{})
+	if f.Delay > 0 {
+		clientFaultsTotal.inc("delay")
+		log.Printf("Delaying outbound request %v", f.Delay)
+		if !sleepContext(ctx, f.Delay) {
+			return ctx.Err()
+		}
+	}
+	if f.Fail {
+		clientFaultsTotal.inc("error")
+		return errClientFault
+	}
+	return nil
+}
+
+// clientFaultTransport injects the client faults in the requests sent through the next transport
+type clientFaultTransport struct {
+	next http.RoundTripper
+}
+
+func (t clientFaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := injectClientFaults(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
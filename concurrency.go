@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var (
+	shedRequestsTotal = newCounterVec("rollouts_demo_shed_requests_total",
+		"Total number of requests shed because the maximum concurrency was reached.", "reason")
+	inflightRequests = newGaugeVec("rollouts_demo_inflight_requests",
+		"Number of requests currently served.")
+	queuedRequests = newGaugeVec("rollouts_demo_queued_requests",
+		"Number of requests currently waiting for a concurrency slot.")
+	queueTime = newHistogramVec("rollouts_demo_queue_time_seconds",
+		"Time spent by the requests waiting for a concurrency slot.", defaultDurationBuckets)
+)
+
+// concurrencyLimiter limits the number of requests served concurrently. When saturated, requests
+// wait in a bounded queue for a slot, modeling realistic saturation, and are shed with a 503 when
+// the queue is full or the wait times out.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	maxWait time.Duration
+}
+
+func newConcurrencyLimiter(max, queueDepth int, maxWait time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:   make(chan struct{}, max),
+		queue:   make(chan struct{}, queueDepth),
+		maxWait: maxWait,
+	}
+}
+
+// acquire waits for a concurrency slot, returning the time spent in the queue and the reason the
+// request was shed, if it was
+func (l *concurrencyLimiter) acquire(r *http.Request) (time.Duration, string) {
+	select {
+	case l.slots <- struct{}{}:
+		return 0, ""
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return 0, "queue_full"
+	}
+	queuedRequests.inc()
+	defer func() {
+		queuedRequests.add(-1)
+		<-l.queue
+	}()
+
+	start := time.Now()
+	timeout := time.NewTimer(l.maxWait)
+	defer timeout.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return time.Since(start), ""
+	case <-timeout.C:
+		return time.Since(start), "queue_timeout"
+	case <-r.Context().Done():
+		return time.Since(start), "canceled"
+	}
+}
+
+func (l *concurrencyLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queued, shedReason := l.acquire(r)
+		queueTime.observe(queued.Seconds())
+		w.Header().Set("X-Queue-Time", queued.String())
+		if shedReason != "" {
+			shedRequestsTotal.inc(shedReason)
+			w.Header().Set("X-Shed", "true")
+			server.WriteError(w, r, http.StatusServiceUnavailable, "maximum concurrency reached")
+			return
+		}
+		inflightRequests.inc()
+		defer func() {
+			inflightRequests.add(-1)
+			<-l.slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsConfig holds the Cross-Origin Resource Sharing settings applied to the API endpoints. CORS is
+// disabled when no allowed origins are configured.
+type corsConfig struct {
+	allowedOrigins []string
+	allowedMethods []string
+	allowedHeaders []string
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header for the given origin, or
+// an empty string if the origin is not allowed
+func (c corsConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.allowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// wrap adds the CORS response headers to the handler responses and answers preflight requests
+func (c corsConfig) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if len(c.allowedOrigins) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowOrigin := c.allowOrigin(origin)
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			// lets the UI show which pods served it when hosted on another origin
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(identityHeaderNames, ", "))
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next(w, r)
+			return
+		}
+		// preflight request
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.allowedMethods, ", "))
+			if len(c.allowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.allowedHeaders, ", "))
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var cpuBurnGoroutines = newGaugeVec("rollouts_demo_cpu_burn_goroutines",
+	"Number of goroutines burning a CPU each.")
+
+// cpuBurner runs the goroutines burning a CPU each, whose number can be changed at runtime through
+// the admin API, so CPU-driven autoscaling demos can be scripted live
+type cpuBurner struct {
+	mu sync.Mutex
+	// stops holds the channel stopping each running goroutine
+	stops []chan struct{}
+}
+
+var burner cpuBurner
+
+// parseCPUBurn parses a number of CPUs to burn, or all for every CPU the process can use, i.e.
+// GOMAXPROCS, bounded by the CPU limit of the container. Larger numbers are rejected, as the extra
+// goroutines wouldn't burn any more CPU.
+func parseCPUBurn(value string) (int, error) {
+	procs := runtime.GOMAXPROCS(0)
+	if value == "all" {
+		return procs, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number of CPUs to burn %q, expected a number or all", value)
+	}
+	if n > procs {
+		return 0, fmt.Errorf("cannot burn %d CPUs, the process can use %d", n, procs)
+	}
+	return n, nil
+}
+
+// set starts or stops goroutines until n of them are burning
+func (b *cpuBurner) set(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n == len(b.stops) {
+		return
+	}
+	log.Printf("Burning %d CPUs, previously %d", n, len(b.stops))
+	for len(b.stops) < n {
+		stop := make(chan struct{})
+		b.stops = append(b.stops, stop)
+		go burnCPU(len(b.stops)-1, stop)
+	}
+	for len(b.stops) > n {
+		close(b.stops[len(b.stops)-1])
+		b.stops = b.stops[:len(b.stops)-1]
+	}
+	cpuBurnGoroutines.set(float64(n))
+}
+
+func (b *cpuBurner) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.stops)
+}
+
+func burnCPU(cpu int, stop <-chan struct{}) {
+	noop := func() {}
+	for {
+		select {
+		case <-stop:
+			log.Printf("Stopped CPU burn #%d", cpu)
+			return
+		default:
+			noop()
+		}
+	}
+}
+
+// cpuBurnResponse is the body of the CPU burn responses
+type cpuBurnResponse struct {
+	XMLName xml.Name `json:"-" xml:"cpuBurn"`
+	CPUs    int      `json:"cpus" xml:"cpus"`
+}
+
+// adminCPUBurn returns the number of burning CPUs on GET, changes it on PUT or POST, e.g. with
+// {"cpus":2} or {"cpus":"all"}, and stops the burn on DELETE
+func adminCPUBurn(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			CPUs json.RawMessage `json:"cpus"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&request); err != nil {
+			if !bodyTooLarge(w, r, "cpu-burn", err) {
+				server.WriteError(w, r, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+		var value string
+		if json.Unmarshal(request.CPUs, &value) != nil {
+			value = string(request.CPUs)
+		}
+		n, err := parseCPUBurn(value)
+		if err != nil {
+			server.WriteError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		burner.set(n)
+	case http.MethodDelete:
+		burner.set(0)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	server.WriteResponse(w, r, http.StatusOK, cpuBurnResponse{CPUs: burner.count()})
+}
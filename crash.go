@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// crashExitCode is the exit code of the process crashing after serving the configured number of
+// requests
+const crashExitCode = 1
+
+// crashAfter exits the process with a non-zero code once it served the given number of requests,
+// to test restart policies, crash-loop backoff and rollout abort conditions. It wraps the application
+// routes only, so the probes don't count.
+func crashAfter(requests int64, next http.Handler) http.Handler {
+	var served int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if atomic.AddInt64(&served, 1) != requests {
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		log.Printf("Crashing after serving %d requests", requests)
+		os.Exit(crashExitCode)
+	})
+}
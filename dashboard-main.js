@@ -0,0 +1,6 @@
+import {Charts, Controls, Dashboard} from './dashboard.js';
+
+new Charts().run();
+const controls = new Controls(document.getElementById("controls"), document.getElementById("controlsDisabled"));
+controls.load();
+new Dashboard(document.getElementById("grid"), document.getElementById("summary"), document.getElementById("status"), controls).run();
@@ -0,0 +1,249 @@
+// Number of recent /color responses rendered in the grid
+const GridSize = 200
+
+const capitalize = (s) => s.charAt(0).toUpperCase() + s.slice(1)
+
+// darken returns the color of the failed responses, like the particles of the main page
+const darken = (color) => color == "yellow" ? "GoldenRod" : "dark" + color
+
+export class Dashboard {
+    constructor(grid, summary, status, controls) {
+        this.grid = grid;
+        this.summary = summary;
+        this.status = status;
+        this.controls = controls;
+        this.responses = [];
+
+        for (let i = 0; i < GridSize; i++) {
+            const cell = document.createElement('div');
+            cell.className = "cell";
+            this.grid.appendChild(cell);
+        }
+    }
+
+    // addResponse renders the response at the top left of the grid, shifting the older ones
+    addResponse(event) {
+        this.responses.unshift(event);
+        this.responses = this.responses.slice(0, GridSize);
+        this.responses.forEach((response, i) => {
+            const cell = this.grid.children[i];
+            const failed = response.status >= 500;
+            cell.style["background"] = failed ? darken(response.color) : response.color;
+            cell.classList.toggle("failed", failed);
+            cell.title = response.color + " " + response.status + " " + Math.round(response.latencyMs) + "ms";
+        });
+        this.renderSummary();
+    }
+
+    // renderSummary shows the share and error rate of each color in the grid
+    renderSummary() {
+        const counts = new Map();
+        this.responses.forEach((response) => {
+            const count = counts.get(response.color) || {total: 0, failed: 0};
+            count.total++;
+            if (response.status >= 500) {
+                count.failed++;
+            }
+            counts.set(response.color, count);
+        });
+        this.summary.innerHTML = "";
+        Array.from(counts.keys()).sort().forEach((color) => {
+            const count = counts.get(color);
+            const row = this.summary.insertRow();
+            const square = document.createElement('div');
+            square.className = "square";
+            square.style["background"] = color;
+            row.insertCell().appendChild(square);
+            row.insertCell().innerText = Math.round(100 * count.total / this.responses.length) + "%";
+            row.insertCell().innerText = Math.round(100 * count.failed / count.total) + "% errors";
+        });
+    }
+
+    run() {
+        this.reconnections = 0;
+        this.retryDelay = 500;
+        this.connect();
+    }
+
+    // connect opens the WebSocket stream, reconnecting with a growing delay whenever it closes, e.g.
+    // when the pod terminates, the hello message then telling which pod took over
+    connect() {
+        const url = new URL('./ws', window.location.href);
+        url.protocol = url.protocol == "https:" ? "wss:" : "ws:";
+        const socket = new WebSocket(url);
+        socket.addEventListener("open", () => {
+            this.retryDelay = 500;
+        });
+        socket.addEventListener("close", (event) => {
+            this.reconnections++;
+            this.status.innerText = "Reconnecting (" + (event.reason || "connection lost") + ")...";
+            setTimeout(this.connect.bind(this), this.retryDelay);
+            this.retryDelay = Math.min(2 * (this.retryDelay || 500), 5000);
+        });
+        socket.addEventListener("message", (message) => {
+            const data = JSON.parse(message.data);
+            if (data.type == "hello") {
+                const pod = data.pod.name || "unknown pod";
+                const role = data.pod.rolloutRole ? " (" + data.pod.rolloutRole + ")" : "";
+                this.status.innerText = "Connected to " + pod + role + ", " + this.reconnections + " reconnections";
+            } else if (data.type == "request" && data.request.handler == "color" && data.request.color) {
+                this.addResponse(data.request);
+                this.controls.addColor(data.request.color);
+            }
+        });
+    }
+}
+
+// Controls change the fault injection settings of the pod through the admin API, which must be
+// served on the user listeners with --ui-controls. Zero values leave the faults unset.
+export class Controls {
+    constructor(panel, disabled) {
+        this.panel = panel;
+        this.disabled = disabled;
+        this.settings = null;
+        this.colorSliders = new Map();
+
+        this.errorRate = document.getElementById("errorRate");
+        this.errorRateText = document.getElementById("errorRateText");
+        this.latency = document.getElementById("latency");
+        this.latencyText = document.getElementById("latencyText");
+        this.colorErrorRates = document.getElementById("colorErrorRates");
+
+        this.errorRate.addEventListener("input", () => this.errorRateText.value = this.errorRate.value + "%");
+        this.latency.addEventListener("input", () => this.latencyText.value = this.latency.value + "s");
+        // the settings are only sent once the slider is released, not on every move
+        this.errorRate.addEventListener("change", this.save.bind(this));
+        this.latency.addEventListener("change", this.save.bind(this));
+    }
+
+    load() {
+        fetch('./admin/settings', {headers: {"Accept": "application/json"}})
+        .then((res) => res.ok ? res.json() : Promise.reject(res.status))
+        .then((settings) => {
+            this.settings = settings;
+            this.panel.hidden = false;
+            this.disabled.hidden = true;
+            this.errorRate.value = settings.errorRate || 0;
+            this.errorRateText.value = this.errorRate.value + "%";
+            this.latency.value = settings.latency || 0;
+            this.latencyText.value = this.latency.value + "s";
+            Object.keys(settings.colorErrorRates || {}).forEach((color) => this.addColor(color));
+        })
+        .catch(() => {
+            this.panel.hidden = true;
+            this.disabled.hidden = false;
+        });
+    }
+
+    // addColor adds the 500 error rate slider of a color, once the settings are loaded
+    addColor(color) {
+        if (this.settings == null || this.colorSliders.has(color)) {
+            return;
+        }
+        const label = document.createElement('label');
+        const text = document.createElement('output');
+        const slider = document.createElement('input');
+        slider.type = "range";
+        slider.min = 0;
+        slider.max = 100;
+        slider.className = "slider";
+        slider.value = (this.settings.colorErrorRates || {})[color] || 0;
+        label.innerText = capitalize(color) + " 500 Error Rate: ";
+        text.value = slider.value + "%";
+        slider.addEventListener("input", () => text.value = slider.value + "%");
+        slider.addEventListener("change", this.save.bind(this));
+        this.colorSliders.set(color, slider);
+        this.colorErrorRates.append(label, text, slider);
+    }
+
+    save() {
+        const settings = {
+            color: this.settings.color,
+            colorWeights: this.settings.colorWeights,
+        };
+        if (this.errorRate.value > 0) {
+            settings.errorRate = parseInt(this.errorRate.value);
+        }
+        if (this.latency.value > 0) {
+            settings.latency = parseInt(this.latency.value);
+        }
+        this.colorSliders.forEach((slider, color) => {
+            if (slider.value > 0) {
+                settings.colorErrorRates = settings.colorErrorRates || {};
+                settings.colorErrorRates[color] = parseInt(slider.value);
+            }
+        });
+        fetch('./admin/settings', {
+            method: "PUT",
+            headers: {"Accept": "application/json"},
+            body: JSON.stringify(settings),
+        })
+        .then((res) => res.ok ? res.json() : Promise.reject(res.status))
+        .then((updated) => this.settings = updated)
+        .catch((status) => console.log("Could not update the settings: " + status));
+    }
+}
+
+// Number of seconds between the refreshes of the charts, the duration of the /stats intervals
+const ChartsRefreshSeconds = 5
+
+// Sparkline draws a series of values on a canvas, scaled to its maximum
+class Sparkline {
+    constructor(canvas, text, format) {
+        this.canvas = canvas;
+        this.text = text;
+        this.format = format;
+    }
+
+    draw(values) {
+        const context = this.canvas.getContext('2d');
+        const width = this.canvas.width;
+        const height = this.canvas.height;
+        context.clearRect(0, 0, width, height);
+        const max = Math.max(...values, 0);
+        context.beginPath();
+        context.strokeStyle = "#4CAF50";
+        context.lineWidth = 2;
+        values.forEach((value, i) => {
+            const x = width * i / Math.max(values.length - 1, 1);
+            const y = max > 0 ? height - 1 - (height - 2) * value / max : height - 1;
+            if (i == 0) {
+                context.moveTo(x, y);
+            } else {
+                context.lineTo(x, y);
+            }
+        });
+        context.stroke();
+        this.text.value = values.length > 0 ? this.format(values[values.length - 1]) : "-";
+    }
+}
+
+// Charts draws the p50 and p99 latency and the error rate of the /color responses of the last
+// minutes from /stats, so the rollout degradation is visible without Grafana
+export class Charts {
+    constructor() {
+        const ms = (value) => Math.round(value) + "ms";
+        this.p50 = new Sparkline(document.getElementById("p50Chart"), document.getElementById("p50Text"), ms);
+        this.p99 = new Sparkline(document.getElementById("p99Chart"), document.getElementById("p99Text"), ms);
+        this.errorRate = new Sparkline(document.getElementById("errorRateChart"), document.getElementById("errorRateChartText"),
+            (value) => Math.round(100 * value) + "%");
+    }
+
+    refresh() {
+        fetch('./stats', {headers: {"Accept": "application/json"}})
+        .then((res) => res.ok ? res.json() : Promise.reject(res.status))
+        .then((stats) => {
+            // the current interval is still in progress
+            const points = stats.points.slice(0, -1);
+            this.p50.draw(points.map((point) => point.p50Ms));
+            this.p99.draw(points.map((point) => point.p99Ms));
+            this.errorRate.draw(points.map((point) => point.errorRate));
+        })
+        .catch((status) => console.log("Could not get the stats: " + status));
+    }
+
+    run() {
+        this.refresh();
+        setInterval(this.refresh.bind(this), ChartsRefreshSeconds * 1000);
+    }
+}
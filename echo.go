@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// maxEchoBody limits the size of the request body returned by /echo
+const maxEchoBody = 1 << 20
+
+// echoResponse describes the request received by /echo
+type echoResponse struct {
+	XMLName    xml.Name    `json:"-" xml:"request"`
+	Method     string      `json:"method" xml:"method"`
+	URL        string      `json:"url" xml:"url"`
+	Proto      string      `json:"proto" xml:"proto"`
+	Host       string      `json:"host" xml:"host"`
+	RemoteAddr string      `json:"remoteAddr" xml:"remoteAddr"`
+	Headers    echoHeaders `json:"headers" xml:"headers"`
+	Body       string      `json:"body" xml:"body"`
+}
+
+type echoHeaders http.Header
+
+// MarshalXML encodes the headers as <header name="...">value</header> elements
+func (h echoHeaders) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range h[name] {
+			header := xml.StartElement{
+				Name: xml.Name{Local: "header"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+			}
+			if err := e.EncodeElement(value, header); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// echo returns the method, headers, remote address and body of the request, which helps debugging
+// the header manipulations of ingresses and meshes during routing demos
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxEchoBody))
+	if err != nil {
+		server.WriteError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	headers := r.Header.Clone()
+	if r.Host != "" {
+		headers.Set("Host", r.Host)
+	}
+	server.WriteResponse(w, r, http.StatusOK, echoResponse{
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    echoHeaders(headers),
+		Body:       string(body),
+	})
+}
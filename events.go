@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// requestEvent describes a served request. It is built by the instrument middleware once the
+// handler returns and dispatched to the registered request listeners.
+type requestEvent struct {
+	Time          time.Time `json:"time"`
+	Handler       string    `json:"handler"`
+	Color         string    `json:"color,omitempty"`
+	Status        int       `json:"status"`
+	LatencyMs     float64   `json:"latencyMs"`
+	DelayMs       float64   `json:"delayMs,omitempty"`
+	InjectedError bool      `json:"injectedError,omitempty"`
+	Subject       string    `json:"subject,omitempty"`
+	Groups        []string  `json:"groups,omitempty"`
+}
+
+// requestInfo collects details about a request while it is being served
+type requestInfo struct {
+	color         string
+	delay         time.Duration
+	injectedError bool
+	// subject and groups are the claims of the validated bearer token, if any
+	subject string
+	groups  []string
+	// mirrored requests are shadow copies of real requests, served without side effects
+	mirrored bool
+	// analysis requests are sent by the Argo Rollouts analysis rather than by users
+	analysis bool
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo returns the request carrying the info in its context, for the handlers served past
+// a wrapper replacing the response writer
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+// requestInfoFrom returns the info of the request being served, or an empty info if the request
+// was not instrumented. The info is carried by the response writer of the instrument middleware
+// rather than by the request context, which would be allocated on every request, and by the
+// context past the wrappers replacing the writer.
+func requestInfoFrom(w http.ResponseWriter, r *http.Request) *requestInfo {
+	if rec, ok := w.(*statusRecorder); ok {
+		return &rec.info
+	}
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// requestListeners are notified after every instrumented request. They are registered during
+// startup, before the servers start, and must not block.
+var requestListeners []func(requestEvent)
+
+func onRequest(listener func(requestEvent)) {
+	requestListeners = append(requestListeners, listener)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+)
+
+var upstreamFallbacksTotal = newCounterVec("rollouts_demo_upstream_fallbacks_total",
+	"Total number of failed upstream requests replaced by a fallback color.", "upstream")
+
+// failPolicy decides how upstream failures are handled: fail-closed turns them into local 5xx
+// responses, fail-open replaces the upstream color by a locally generated fallback color
+type failPolicy string
+
+const (
+	failClosed failPolicy = "closed"
+	failOpen   failPolicy = "open"
+)
+
+var (
+	upstreamFailPolicy = failClosed
+	// upstreamFallbackColor is the color used in place of a failed upstream one by the fail-open
+	// policy. The local color is used when empty.
+	upstreamFallbackColor string
+)
+
+func parseFailPolicy(value string) (failPolicy, error) {
+	switch p := failPolicy(value); p {
+	case failClosed, failOpen:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid upstream fail policy %q, expected closed or open", value)
+}
+
+// fallback replaces the color of a failed upstream result by the fallback color when failing open,
+// keeping its status and error for diagnostics
+func (p failPolicy) fallback(result *upstreamResult, localColor string) {
+	if p != failOpen || !result.failed() {
+		return
+	}
+	color := upstreamFallbackColor
+	if color == "" {
+		color = localColor
+	}
+	result.Color, result.Chain, result.Fallback = color, []string{color}, true
+	upstreamFallbacksTotal.inc(result.Name)
+}
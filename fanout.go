@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// fanoutUpstreams are the services called in parallel by /colors
+var fanoutUpstreams []*upstreamService
+
+// fanoutUpstreamsFromEnv builds the fan-out upstreams from the FANOUT_URLS environment variable, a
+// comma separated list of upstream URLs
+func fanoutUpstreamsFromEnv() ([]*upstreamService, error) {
+	var upstreams []*upstreamService
+	for _, rawURL := range config.SplitList(os.Getenv("FANOUT_URLS")) {
+		u, err := newUpstreamService("", rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FANOUT_URLS value: %v", err)
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+// fanoutResponse is the body of the /colors responses
+type fanoutResponse struct {
+	XMLName   xml.Name         `json:"-" xml:"colors"`
+	Upstreams []upstreamResult `json:"upstreams" xml:"upstream"`
+	Failed    int              `json:"failed" xml:"failed"`
+}
+
+// getColors calls every fan-out upstream in parallel and aggregates their colors, statuses and
+// latencies, to demo partial failures in fan-out architectures. Unless failing open, the response
+// is a 502 when every upstream failed, or a 504 when they all timed out.
+func getColors(w http.ResponseWriter, r *http.Request) {
+	if len(fanoutUpstreams) == 0 {
+		server.WriteError(w, r, http.StatusNotFound, "no fan-out upstreams configured")
+		return
+	}
+	response := fanoutResponse{Upstreams: make([]upstreamResult, len(fanoutUpstreams))}
+	current, _ := state.get()
+	localColor := currentColor(current)
+	var wg sync.WaitGroup
+	for i, u := range fanoutUpstreams {
+		wg.Add(1)
+		go func(i int, u *upstreamService, r *http.Request) {
+			defer wg.Done()
+			result := u.call(r)
+			upstreamFailPolicy.fallback(&result, localColor)
+			response.Upstreams[i] = result
+		}(i, u, forGoroutine(r))
+	}
+	wg.Wait()
+	timedOut := 0
+	for _, result := range response.Upstreams {
+		if result.failed() && !result.Fallback {
+			response.Failed++
+		}
+		if result.TimedOut && !result.Fallback {
+			timedOut++
+		}
+	}
+	w.Header().Set("X-Upstream-Fail-Policy", string(upstreamFailPolicy))
+	status := http.StatusOK
+	if timedOut == len(response.Upstreams) {
+		status = http.StatusGatewayTimeout
+	} else if response.Failed == len(response.Upstreams) {
+		status = http.StatusBadGateway
+	}
+	server.WriteResponse(w, r, status, response)
+}
@@ -1,5 +1,20 @@
 module github.com/argoproj/rollouts-demo
 
-go 1.12
+go 1.16
 
-require github.com/newrelic/go-agent/v3 v3.11.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/gorilla/websocket v1.4.2
+	github.com/nats-io/nats.go v1.11.0
+	github.com/newrelic/go-agent/v3 v3.11.0
+	github.com/segmentio/kafka-go v0.4.40
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	go.opentelemetry.io/proto/otlp v0.16.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/grpc v1.46.0
+	google.golang.org/protobuf v1.28.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/server"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var grpcRequestsTotal = newCounterVec("rollouts_demo_grpc_requests_total",
+	"Total number of gRPC color requests served.", "code")
+
+// getColorMethod is the only method of the gRPC color service. The service is described by hand
+// with the well-known wrapper types, so no generated code is needed:
+//
+//	service ColorService {
+//	  rpc GetColor(google.protobuf.Empty) returns (google.protobuf.StringValue);
+//	}
+const getColorMethod = "/rolloutsdemo.ColorService/GetColor"
+
+type colorServer interface {
+	getColor(ctx context.Context, in *emptypb.Empty) (*wrapperspb.StringValue, error)
+}
+
+var colorServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rolloutsdemo.ColorService",
+	HandlerType: (*colorServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "GetColor",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(emptypb.Empty)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(colorServer).getColor(ctx, req.(*emptypb.Empty))
+			}
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: getColorMethod}, handler)
+		},
+	}},
+	Metadata: "rollouts-demo",
+}
+
+// grpcColorServer serves the color over gRPC, chaining to the next upstream like /color, so
+// mixed-protocol traces and mesh gRPC routing can be demonstrated
+type grpcColorServer struct{}
+
+// serveGRPC starts the gRPC color service on the given address. The returned server is stopped
+// gracefully on shutdown.
+func serveGRPC(addr string) (*grpc.Server, error) {
+	listener, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&colorServiceDesc, &grpcColorServer{})
+	log.Printf("Started gRPC server on %s", addr)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	return server, nil
+}
+
+// requestFromMetadata returns an HTTP request carrying the gRPC metadata as headers, so the chaining
+// helpers can be shared with /color
+func requestFromMetadata(ctx context.Context) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, getColorMethod, nil)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	return r
+}
+
+func (s *grpcColorServer) getColor(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	r := requestFromMetadata(ctx)
+	txnCtx, txn := apmProvider.StartTransaction(ctx, getColorMethod, r.Header)
+	defer txn.End()
+	r = r.WithContext(txnCtx)
+
+	current, _ := state.get()
+	color := currentColor(current)
+	f := decideFaults(current, server.ColorParameters{Color: color})
+	webhook.notify("grpc", color, f)
+	noticeInjectedError(txn, color, f)
+	if f.Delay > 0 {
+		log.Printf("Delaying gRPC %s %v", color, f.Delay)
+		if err := tracedSleep(r.Context(), "injected delay", f.Delay); err != nil {
+			log.Printf("Aborted the delay of gRPC %s: %v", color, err)
+			if err == server.ErrDraining {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+			return nil, status.FromContextError(err).Err()
+		}
+	}
+
+	code := codes.OK
+	var err error
+	if f.Fail {
+		code, err = codes.Internal, status.Errorf(codes.Internal, "%s failed", color)
+	}
+	if next := nextUpstream(r); next != nil {
+		result := next.call(r)
+		upstreamFailPolicy.fallback(&result, color)
+		header := make(http.Header)
+		setUpstreamHeaders(header, color, result)
+		md := metadata.MD{}
+		for key, values := range header {
+			md.Set(strings.ToLower(key), values...)
+		}
+		if err := grpc.SetHeader(ctx, md); err != nil {
+			log.Printf("Could not set the gRPC headers: %v", err)
+		}
+		if result.failed() && !result.Fallback && !f.Fail {
+			code = codes.Unavailable
+			if result.TimedOut {
+				code = codes.DeadlineExceeded
+			}
+			err = status.Errorf(code, "upstream %s failed: %s", result.Name, result.Error)
+		}
+	}
+	grpcRequestsTotal.inc(code.String())
+	addTransactionAttributes(txn, &requestInfo{color: color, delay: f.Delay, injectedError: f.Fail})
+	if err != nil {
+		return nil, err
+	}
+	return wrapperspb.String(color), nil
+}
+
+// grpcStatus maps the gRPC status codes to the HTTP status codes reported for the upstreams
+func grpcStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 0
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.NotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// doGRPC requests the color of a gRPC upstream, propagating the chain hop, the forwarded headers and
+// the trace context as metadata
+func (u *upstreamService) doGRPC(r *http.Request) upstreamResult {
+	result := upstreamResult{Name: u.name}
+	ctx, cancel := context.WithTimeout(r.Context(), u.timeout)
+	defer cancel()
+	md := metadata.Pairs("x-chain-hop", strconv.Itoa(chainHop(r)+1))
+	for _, name := range append([]string{"X-User-Id", "X-Mirrored"}, traceHeaders...) {
+		if value := r.Header.Get(name); value != "" {
+			md.Set(strings.ToLower(name), value)
+		}
+	}
+	txn := telemetry.FromContext(r.Context())
+	traceHeader := make(http.Header)
+	txn.InjectHeaders(traceHeader)
+	for key, values := range traceHeader {
+		md.Set(strings.ToLower(key), values...)
+	}
+	defer txn.StartSegment("gRPC " + u.name + getColorMethod).End()
+	if err := injectClientFaults(ctx); err != nil {
+		result.TimedOut = err == context.DeadlineExceeded
+		result.Error = err.Error()
+		return result
+	}
+
+	var header metadata.MD
+	out := &wrapperspb.StringValue{}
+	err := u.conn.Invoke(metadata.NewOutgoingContext(ctx, md), getColorMethod, &emptypb.Empty{}, out, grpc.Header(&header))
+	code := status.Code(err)
+	result.Status = grpcStatus(code)
+	if err != nil {
+		result.TimedOut = code == codes.DeadlineExceeded
+		result.Error = err.Error()
+		return result
+	}
+	result.Color = out.GetValue()
+	if values := header.Get("x-color-chain"); len(values) > 0 {
+		result.Chain = config.SplitList(values[0])
+	}
+	if len(result.Chain) == 0 {
+		result.Chain = []string{result.Color}
+	}
+	return result
+}
+
+// dialGRPC connects to a gRPC upstream in the background
+func dialGRPC(host string, connectTimeout time.Duration) (*grpc.ClientConn, error) {
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	return grpc.Dial(host,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+var (
+	upstreamHedgesTotal = newCounterVec("rollouts_demo_upstream_hedges_total",
+		"Total number of hedged upstream requests sent.", "upstream")
+	upstreamHedgeWinsTotal = newCounterVec("rollouts_demo_upstream_hedge_wins_total",
+		"Total number of hedged upstream requests answered before the original request.", "upstream")
+)
+
+// upstreamHedgeDelay is the delay after which a second request is sent to an upstream which has not
+// answered yet, the first response being used. Hedging is disabled when 0.
+var upstreamHedgeDelay time.Duration
+
+type hedgeResult struct {
+	result upstreamResult
+	hedge  bool
+}
+
+// hedgedAttempt sends a request to the upstream and, if it has not answered after the hedge delay,
+// a second one, returning the first response to mitigate tail latency. The slower request is
+// canceled.
+func (u *upstreamService) hedgedAttempt(r *http.Request) upstreamResult {
+	if upstreamHedgeDelay <= 0 {
+		return u.attempt(r)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+	results := make(chan hedgeResult, 2)
+	go func(r *http.Request) {
+		results <- hedgeResult{result: u.attempt(r)}
+	}(forGoroutine(r))
+
+	timer := time.NewTimer(upstreamHedgeDelay)
+	defer timer.Stop()
+	select {
+	case res := <-results:
+		return res.result
+	case <-timer.C:
+	}
+
+	upstreamHedgesTotal.inc(u.name)
+	go func(r *http.Request) {
+		results <- hedgeResult{result: u.attempt(r), hedge: true}
+	}(forGoroutine(r))
+	res := <-results
+	if res.hedge {
+		upstreamHedgeWinsTotal.inc(u.name)
+	}
+	return res.result
+}
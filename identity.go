@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+// version is the version of the application, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// podIdentity describes the pod serving the requests. It is read from environment variables
+// populated through the downward API.
+type podIdentity struct {
+	// rolloutRole is the canary/stable role set by the Argo Rollouts ephemeral metadata
+	rolloutRole string
+	// podTemplateHash is the rollouts-pod-template-hash label of the pod
+	podTemplateHash string
+	podName         string
+	podNamespace    string
+	nodeName        string
+	// zone and region are the topology.kubernetes.io labels of the node running the pod
+	zone   string
+	region string
+}
+
+var identity = podIdentityFromEnv()
+
+func podIdentityFromEnv() podIdentity {
+	return podIdentity{
+		rolloutRole:     os.Getenv("ROLLOUT_ROLE"),
+		podTemplateHash: os.Getenv("POD_TEMPLATE_HASH"),
+		podName:         os.Getenv("POD_NAME"),
+		podNamespace:    os.Getenv("POD_NAMESPACE"),
+		nodeName:        os.Getenv("NODE_NAME"),
+		zone:            os.Getenv("ZONE"),
+		region:          os.Getenv("REGION"),
+	}
+}
+
+// identityHeaderNames are the names of the response headers describing the pod
+var identityHeaderNames = []string{
+	"X-Rollout-Role",
+	"X-Pod-Template-Hash",
+	"X-Pod-Name",
+	"X-Pod-Namespace",
+	"X-Node-Name",
+	"X-Zone",
+	"X-Region",
+}
+
+// headers returns the response headers describing the pod, omitting the unknown values
+func (p podIdentity) headers() http.Header {
+	values := []string{p.rolloutRole, p.podTemplateHash, p.podName, p.podNamespace, p.nodeName, p.zone, p.region}
+	headers := make(http.Header)
+	for i, name := range identityHeaderNames {
+		if values[i] != "" {
+			headers.Set(name, values[i])
+		}
+	}
+	return headers
+}
+
+// withIdentityHeaders adds the identity of the pod to the responses, so clients can see which side
+// of the rollout and which replica served them. The header values are shared by the responses
+// rather than allocated for each of them.
+func withIdentityHeaders(next http.HandlerFunc) http.HandlerFunc {
+	headers := identity.headers()
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,13 @@
+import {App} from './app.js';
+
+const canvas = document.createElement("canvas");
+document.body.appendChild(canvas);
+canvas.width = window.innerWidth;
+canvas.height = window.innerHeight;
+
+window.addEventListener('resize', function() {
+    canvas.width = window.innerWidth;
+    canvas.height = window.innerHeight;
+});
+
+new App(canvas).run();
@@ -0,0 +1,149 @@
+// Package config parses the values of the flags and environment variables configuring the demo:
+// lists, key:value pairs, weights, durations, sizes and networks.
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SplitList splits a comma separated list, ignoring empty entries
+func SplitList(list string) []string {
+	var out []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// Pair is an entry of a comma separated list of key:value pairs
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// ParsePairs parses a comma separated list of key:value pairs, e.g. "blue:80,green:20"
+func ParsePairs(list string) ([]Pair, error) {
+	var out []Pair
+	for _, entry := range SplitList(list) {
+		split := strings.SplitN(entry, ":", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid entry %q, expected <key>:<value>", entry)
+		}
+		key, value := strings.TrimSpace(split[0]), strings.TrimSpace(split[1])
+		if key == "" || value == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected <key>:<value>", entry)
+		}
+		out = append(out, Pair{Key: key, Value: value})
+	}
+	return out, nil
+}
+
+// ParseWeights parses a comma separated list of key:weight pairs, e.g. "blue:80,green:20"
+func ParseWeights(list string) (map[string]int, error) {
+	pairs, err := ParsePairs(list)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]int, len(pairs))
+	for _, p := range pairs {
+		weight, err := strconv.Atoi(p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q for %s", p.Value, p.Key)
+		}
+		weights[p.Key] = weight
+	}
+	return weights, ValidateWeights(weights)
+}
+
+// ValidateWeights checks that the weights are not negative and that at least one is positive
+func ValidateWeights(weights map[string]int) error {
+	total := 0
+	for key, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of %s must not be negative, got %d", key, weight)
+		}
+		total += weight
+	}
+	if len(weights) > 0 && total == 0 {
+		return fmt.Errorf("at least one weight must be positive")
+	}
+	return nil
+}
+
+// ParseSeconds parses a duration given either as a number of seconds or as a Go duration, e.g. 90
+// or 1m30s
+func ParseSeconds(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds > math.MaxInt64/int64(time.Second) || seconds < math.MinInt64/int64(time.Second) {
+			return 0, fmt.Errorf("duration %q overflows", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// sizeUnits are the units accepted by ParseSize, the longest suffixes first
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with an optional unit, e.g. 512, 10KB, 100MB or 1GiB
+func ParseSize(value string) (int64, error) {
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(number), strings.ToUpper(unit.suffix)) {
+			number, multiplier = strings.TrimSpace(number[:len(number)-len(unit.suffix)]), unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q overflows", value)
+	}
+	return size * multiplier, nil
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs, single addresses standing for themselves
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range SplitList(list) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "512", want: 512},
+		{value: "512B", want: 512},
+		{value: "10KB", want: 10000},
+		{value: "10kb", want: 10000},
+		{value: "10K", want: 10000},
+		{value: "100MB", want: 100000000},
+		{value: "2G", want: 2000000000},
+		{value: "1KiB", want: 1024},
+		{value: "1GiB", want: 1 << 30},
+		{value: " 4 MiB ", want: 4 << 20},
+		{value: "9223372036854775807", want: 1<<63 - 1},
+		{value: "", wantErr: true},
+		{value: "MB", wantErr: true},
+		{value: "ten", wantErr: true},
+		{value: "1.5MB", wantErr: true},
+		{value: "10TB", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "9223372036854775808", wantErr: true},
+		{value: "9999999999999G", wantErr: true},
+		{value: "8589934592GiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{list: "", want: map[string]int{}},
+		{list: "blue:80,green:20", want: map[string]int{"blue": 80, "green": 20}},
+		{list: " blue : 1 , , green:0 ", want: map[string]int{"blue": 1, "green": 0}},
+		{list: "blue", wantErr: true},
+		{list: "blue:", wantErr: true},
+		{list: ":80", wantErr: true},
+		{list: "blue:eighty", wantErr: true},
+		{list: "blue:-1,green:2", wantErr: true},
+		{list: "blue:0,green:0", wantErr: true},
+		{list: "blue:99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWeights(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWeights(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWeights(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "10.0.0.0/8", want: []string{"10.0.0.0/8"}},
+		{list: "10.1.2.3/8", want: []string{"10.0.0.0/8"}},
+		{list: "192.168.1.10", want: []string{"192.168.1.10/32"}},
+		{list: "::1", want: []string{"::1/128"}},
+		{list: "fd00::/8, 127.0.0.1", want: []string{"fd00::/8", "127.0.0.1/32"}},
+		{list: "localhost", wantErr: true},
+		{list: "10.0.0.0/33", wantErr: true},
+		{list: "10.0.0.0/8,256.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		networks, err := ParseCIDRs(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCIDRs(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, network := range networks {
+			got = append(got, network.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCIDRs(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestParseSeconds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "90", want: 90 * time.Second},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "250ms", want: 250 * time.Millisecond},
+		{value: "", wantErr: true},
+		{value: "ninety", wantErr: true},
+		{value: "90x", wantErr: true},
+		{value: "9223372037", wantErr: true},
+		{value: "-9223372037", wantErr: true},
+		{value: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSeconds(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSeconds(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSeconds(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
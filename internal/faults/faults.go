@@ -0,0 +1,64 @@
+// Package faults decides the faults injected in the responses, from the runtime settings and the
+// parameters sent by the clients.
+package faults
+
+import "time"
+
+// Faults are the faults injected in a response
+type Faults struct {
+	Delay time.Duration
+	Fail  bool
+	// ErrorRate is the probability in percent of the injected error, and ErrorSource the settings
+	// or color parameters it comes from
+	ErrorRate   int
+	ErrorSource string
+}
+
+// Settings are the faults configured in the runtime settings for a color, which take precedence
+// over the parameters sent by the client. Unset faults fall back to the parameters.
+type Settings struct {
+	// Latency is the delay of every response, in seconds
+	Latency *int
+	// ErrorRate is the probability in percent of the responses failing
+	ErrorRate *int
+}
+
+// Parameters are the faults requested by the client for a color
+type Parameters struct {
+	// DelayPercent is the probability in percent of the responses being delayed by DelayLength
+	// seconds
+	DelayPercent *int
+	DelayLength  int
+	// Return500Percent is the probability in percent of the responses failing
+	Return500Percent *int
+}
+
+// Decider decides which faults to inject in the responses
+type Decider struct {
+	intn func(n int) int
+}
+
+// NewDecider returns a decider drawing the faults with intn, which returns a random int in [0, n)
+func NewDecider(intn func(n int) int) *Decider {
+	return &Decider{intn: intn}
+}
+
+// Decide decides which faults to inject in a response. The runtime settings take precedence over
+// the parameters sent by the client.
+func (d *Decider) Decide(s Settings, p Parameters) Faults {
+	var f Faults
+	if s.Latency != nil {
+		f.Delay = time.Duration(*s.Latency) * time.Second
+	} else if p.DelayPercent != nil && *p.DelayPercent > 0 && *p.DelayPercent >= d.intn(100) {
+		f.Delay = time.Duration(p.DelayLength) * time.Second
+	}
+
+	if s.ErrorRate != nil {
+		f.Fail = d.intn(100) < *s.ErrorRate
+		f.ErrorRate, f.ErrorSource = *s.ErrorRate, "settings"
+	} else if p.Return500Percent != nil && *p.Return500Percent > 0 && *p.Return500Percent >= d.intn(100) {
+		f.Fail = true
+		f.ErrorRate, f.ErrorSource = *p.Return500Percent, "parameters"
+	}
+	return f
+}
@@ -0,0 +1,60 @@
+package faults
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	percent := func(n int) *int { return &n }
+	tests := []struct {
+		name     string
+		settings Settings
+		params   Parameters
+		// draw is the random number drawn for each decision
+		draw int
+		want Faults
+	}{
+		{name: "none", draw: 0},
+		{
+			name:     "settings latency",
+			settings: Settings{Latency: percent(2)},
+			params:   Parameters{DelayPercent: percent(100), DelayLength: 5},
+			want:     Faults{Delay: 2 * time.Second},
+		},
+		{
+			name:     "settings latency of 0 overriding the parameters",
+			settings: Settings{Latency: percent(0)},
+			params:   Parameters{DelayPercent: percent(100), DelayLength: 5},
+		},
+		{name: "delayed by the parameters", params: Parameters{DelayPercent: percent(50), DelayLength: 3}, draw: 50, want: Faults{Delay: 3 * time.Second}},
+		{name: "not delayed by the parameters", params: Parameters{DelayPercent: percent(50), DelayLength: 3}, draw: 51},
+		{name: "0 delay percent", params: Parameters{DelayPercent: percent(0), DelayLength: 3}, draw: 0},
+		{
+			name:     "failed by the settings",
+			settings: Settings{ErrorRate: percent(30)},
+			draw:     29,
+			want:     Faults{Fail: true, ErrorRate: 30, ErrorSource: "settings"},
+		},
+		{
+			name:     "not failed by the settings",
+			settings: Settings{ErrorRate: percent(30)},
+			params:   Parameters{Return500Percent: percent(100)},
+			draw:     30,
+			want:     Faults{ErrorRate: 30, ErrorSource: "settings"},
+		},
+		{name: "failed by the parameters", params: Parameters{Return500Percent: percent(40)}, draw: 40, want: Faults{Fail: true, ErrorRate: 40, ErrorSource: "parameters"}},
+		{name: "not failed by the parameters", params: Parameters{Return500Percent: percent(40)}, draw: 41},
+	}
+	for _, tt := range tests {
+		d := NewDecider(func(n int) int {
+			if n != 100 {
+				t.Errorf("%s: got a draw in [0, %d), want [0, 100)", tt.name, n)
+			}
+			return tt.draw
+		})
+		if got := d.Decide(tt.settings, tt.params); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
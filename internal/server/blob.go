@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+const defaultBlobSize = 1 << 20
+
+var blobPattern = []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ\n")
+
+// generatedContent is a seekable stream of generated data, so large blobs can be served without
+// being held in memory
+type generatedContent struct {
+	size   int64
+	offset int64
+}
+
+func (c *generatedContent) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	if remaining := c.size - c.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = blobPattern[(c.offset+int64(i))%int64(len(blobPattern))]
+	}
+	c.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (c *generatedContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// Blob streams generated data of the requested size (e.g. /blob?size=100MB), honoring Range
+// requests. Useful to test ingress buffering, timeouts and bandwidth during canary shifts.
+type Blob struct {
+	// maxSize limits the size of the generated blobs
+	maxSize int64
+}
+
+// NewBlob returns a blob handler generating blobs of up to maxSize bytes
+func NewBlob(maxSize int64) *Blob {
+	return &Blob{maxSize: maxSize}
+}
+
+func (b *Blob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	size := int64(defaultBlobSize)
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		if size, err = config.ParseSize(value); err != nil {
+			WriteError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if size > b.maxSize {
+		WriteError(w, r, http.StatusBadRequest, fmt.Sprintf("size %d exceeds the maximum blob size %d", size, b.maxSize))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "blob", time.Time{}, &generatedContent{size: size})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBlob(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+		wantLength int
+	}{
+		{name: "default size", target: "/blob", wantStatus: http.StatusOK, wantLength: defaultBlobSize},
+		{name: "size", target: "/blob?size=100", wantStatus: http.StatusOK, wantLength: 100},
+		{name: "range", target: "/blob?size=100", header: http.Header{"Range": {"bytes=10-14"}}, wantStatus: http.StatusPartialContent, wantBody: "abcde"},
+		{name: "invalid size", target: "/blob?size=big", wantStatus: http.StatusBadRequest},
+		{name: "above the maximum size", target: "/blob?size=2MiB", wantStatus: http.StatusBadRequest, wantBody: `{"error":"size 2097152 exceeds the maximum blob size 1048576"}`},
+	}
+	b := NewBlob(defaultBlobSize)
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: got body %q, want %q", tt.name, w.Body.String(), tt.wantBody)
+		}
+		if tt.wantLength != 0 && w.Body.Len() != tt.wantLength {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, w.Body.Len(), tt.wantLength)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package server implements the HTTP handlers of the demo and the encoding of their responses. The
+// handlers get the state they serve from their constructors, so they can be tested without starting
+// a server.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/faults"
+)
+
+// ColorParameters are the faults a client requests for a color
+type ColorParameters struct {
+	Color            string `json:"color"`
+	DelayProbability *int   `json:"delayPercent,omitempty"`
+	DelayLength      int    `json:"delayLength,omitempty"`
+
+	Return500Probability *int `json:"return500,omitempty"`
+}
+
+// Faults returns the parameters of the faults requested for the color
+func (p ColorParameters) Faults() faults.Parameters {
+	return faults.Parameters{
+		DelayPercent:     p.DelayProbability,
+		DelayLength:      p.DelayLength,
+		Return500Percent: p.Return500Probability,
+	}
+}
+
+// colorRequest is the body of the color requests, the parameters of the colors
+type colorRequest []ColorParameters
+
+// UnmarshalJSON accepts the "[]" string the UI sends when it has no colors yet as an empty request
+func (c *colorRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == `"[]"` {
+		return nil
+	}
+	return json.Unmarshal(data, (*[]ColorParameters)(c))
+}
+
+// Selection is the color picked for a request
+type Selection struct {
+	Color string
+	// Generation is the generation of the settings the color was picked from, which the entity tags
+	// of the responses depend on
+	Generation uint64
+	// Faults are the faults configured in the settings for the color
+	Faults faults.Settings
+}
+
+// ColorConfig are the dependencies of the color handler. Pick is required, the other functions are
+// optional.
+type ColorConfig struct {
+	// DecodeBody decodes the body of the request into v, replying with an error and returning false
+	// when it can't
+	DecodeBody func(w http.ResponseWriter, r *http.Request, v interface{}) bool
+	// Pick picks the color of the request
+	Pick func(w http.ResponseWriter, r *http.Request) Selection
+	// Faults decides the faults injected in the responses
+	Faults *faults.Decider
+	// OnFaults is called with the faults decided for the request, before they are injected
+	OnFaults func(w http.ResponseWriter, r *http.Request, color string, f faults.Faults)
+	// Sleep injects a delay, returning an error when it is cut short, ErrDraining when the servers
+	// start draining
+	Sleep func(ctx context.Context, name string, d time.Duration) error
+	// ZoneLatency is the latency added to every response
+	ZoneLatency time.Duration
+	// Upstream calls the next upstream, if any, and returns the status of the response given the
+	// status decided so far, and whether an upstream was called
+	Upstream func(w http.ResponseWriter, r *http.Request, color string, status int) (int, bool)
+	// OnServed is called with the color and the status of every response
+	OnServed func(w http.ResponseWriter, r *http.Request, color string, status int)
+	// Headers are the headers added to the responses of each color, shared by the responses
+	Headers map[string]http.Header
+}
+
+// Color serves the color picked for the request, with the faults configured in the settings or
+// requested by the client
+type Color struct {
+	config ColorConfig
+	etags  etagCache
+
+	mu        sync.RWMutex
+	responses map[string]*ColorResponse
+}
+
+// NewColor returns a color handler with the given dependencies
+func NewColor(config ColorConfig) *Color {
+	if config.DecodeBody == nil {
+		config.DecodeBody = func(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+			if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+				WriteError(w, r, http.StatusInternalServerError, err.Error())
+				return false
+			}
+			return true
+		}
+	}
+	if config.Faults == nil {
+		config.Faults = faults.NewDecider(rand.Intn)
+	}
+	if config.OnFaults == nil {
+		config.OnFaults = func(http.ResponseWriter, *http.Request, string, faults.Faults) {}
+	}
+	if config.Sleep == nil {
+		config.Sleep = sleep
+	}
+	if config.Upstream == nil {
+		config.Upstream = func(_ http.ResponseWriter, _ *http.Request, _ string, status int) (int, bool) {
+			return status, false
+		}
+	}
+	if config.OnServed == nil {
+		config.OnServed = func(http.ResponseWriter, *http.Request, string, int) {}
+	}
+	return &Color{config: config}
+}
+
+func (c *Color) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, ok := c.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	selection := c.config.Pick(w, r)
+	color := selection.Color
+	colorParams := ColorParameters{Color: color}
+	for i := range request {
+		if request[i].Color == color {
+			colorParams = request[i]
+		}
+	}
+
+	f := c.config.Faults.Decide(selection.Faults, colorParams.Faults())
+	c.config.OnFaults(w, r, color, f)
+	if f.Delay > 0 {
+		log.Printf("Delaying %s %v", color, f.Delay)
+		if err := c.config.Sleep(r.Context(), "injected delay", f.Delay); err != nil {
+			DelayAborted(w, r, err)
+			return
+		}
+	}
+	if c.config.ZoneLatency > 0 {
+		if err := c.config.Sleep(r.Context(), "zone latency", c.config.ZoneLatency); err != nil {
+			DelayAborted(w, r, err)
+			return
+		}
+	}
+	status := http.StatusOK
+	if f.Fail {
+		status = http.StatusInternalServerError
+	}
+	status, proxied := c.config.Upstream(w, r, color, status)
+	if !proxied && !f.Fail && c.notModified(w, r, color, selection.Generation) {
+		log.Printf("Not modified %s\n", c.response(color))
+		return
+	}
+	c.WriteColor(w, r, color, status)
+}
+
+// decodeRequest decodes the parameters of the colors sent in the body of the request, if any
+func (c *Color) decodeRequest(w http.ResponseWriter, r *http.Request) (colorRequest, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	var request colorRequest
+	ok := c.config.DecodeBody(w, r, &request)
+	return request, ok
+}
+
+// WriteColor writes the color response, with the headers configured for the color
+func (c *Color) WriteColor(w http.ResponseWriter, r *http.Request, color string, status int) {
+	// the cached response is logged rather than the color, which would be allocated to be formatted
+	response := c.response(color)
+	if status < http.StatusInternalServerError {
+		log.Printf("Successful %s\n", response)
+	} else {
+		log.Printf("Returning %d\n", status)
+		log.Printf("%d - %s\n", status, response)
+	}
+	c.config.OnServed(w, r, color, status)
+	for key, values := range c.config.Headers[color] {
+		w.Header()[key] = values
+	}
+	WriteResponse(w, r, status, response)
+}
+
+// response returns the cached response of the color, so the responses of the served colors are
+// encoded only once. The cache is reset when full.
+func (c *Color) response(color string) *ColorResponse {
+	c.mu.RLock()
+	response := c.responses[color]
+	c.mu.RUnlock()
+	if response != nil {
+		return response
+	}
+	response = preformattedColor(color)
+	c.mu.Lock()
+	if c.responses == nil || len(c.responses) >= maxCachedColors {
+		c.responses = make(map[string]*ColorResponse)
+	}
+	c.responses[color] = response
+	c.mu.Unlock()
+	return response
+}
+
+// notModified sets the ETag header of the color response and replies with a 304 when the client
+// already has it
+func (c *Color) notModified(w http.ResponseWriter, r *http.Request, color string, generation uint64) bool {
+	etag := c.etags.header(r, color, generation)
+	w.Header()["Etag"] = etag
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag[0]) {
+		return false
+	}
+	c.config.OnServed(w, r, color, http.StatusNotModified)
+	addHeader(w.Header(), "Vary", varyAccept)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
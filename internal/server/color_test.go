@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/faults"
+)
+
+func TestColor(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	percent := func(n int) *int { return &n }
+	tests := []struct {
+		name string
+		// method, body and header are the ones of the request
+		method string
+		body   string
+		header http.Header
+		// faults are the faults of the settings, and sleep the error of the injected delays
+		faults      faults.Settings
+		zoneLatency time.Duration
+		sleep       error
+		upstream    int
+		// want are the status and body of the response, and wantSleeps the delays injected
+		wantStatus int
+		wantBody   string
+		wantSleeps []time.Duration
+	}{
+		{name: "color", wantStatus: http.StatusOK, wantBody: `"blue"`},
+		{name: "xml", header: http.Header{"Accept": {"application/xml"}}, wantStatus: http.StatusOK, wantBody: `<?xml version="1.0" encoding="UTF-8"?>` + "\n<color>blue</color>"},
+		{name: "text", header: http.Header{"Accept": {"text/plain"}}, wantStatus: http.StatusOK, wantBody: "blue"},
+		{name: "not acceptable", header: http.Header{"Accept": {"image/png"}}, wantStatus: http.StatusNotAcceptable, wantBody: "none of the accepted media types is supported: image/png"},
+		{name: "settings error", faults: faults.Settings{ErrorRate: percent(100)}, wantStatus: http.StatusInternalServerError, wantBody: `"blue"`},
+		{
+			name:        "settings and zone latency",
+			faults:      faults.Settings{Latency: percent(2)},
+			zoneLatency: time.Millisecond,
+			wantStatus:  http.StatusOK,
+			wantBody:    `"blue"`,
+			wantSleeps:  []time.Duration{2 * time.Second, time.Millisecond},
+		},
+		{
+			name:       "draining during the delay",
+			faults:     faults.Settings{Latency: percent(2)},
+			sleep:      ErrDraining,
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"error":"server shutting down"}`,
+			wantSleeps: []time.Duration{2 * time.Second},
+		},
+		{
+			name:   "client gone during the delay",
+			faults: faults.Settings{Latency: percent(2)},
+			sleep:  context.Canceled,
+			// nothing is written to the response
+			wantStatus: http.StatusOK,
+			wantSleeps: []time.Duration{2 * time.Second},
+		},
+		{name: "parameters error", method: http.MethodPost, body: `[{"color":"green","return500":100},{"color":"blue","return500":100}]`, wantStatus: http.StatusInternalServerError, wantBody: `"blue"`},
+		{name: "parameters of another color", method: http.MethodPost, body: `[{"color":"green","return500":100}]`, wantStatus: http.StatusOK, wantBody: `"blue"`},
+		{name: "UI without colors", method: http.MethodPost, body: `"[]"`, wantStatus: http.StatusOK, wantBody: `"blue"`},
+		{name: "invalid body", method: http.MethodPost, body: `{`, wantStatus: http.StatusInternalServerError, wantBody: `{"error":"unexpected EOF"}`},
+		{name: "upstream failure", upstream: http.StatusBadGateway, wantStatus: http.StatusBadGateway, wantBody: `"blue"`},
+	}
+	for _, tt := range tests {
+		var sleeps []time.Duration
+		var served []int
+		c := NewColor(ColorConfig{
+			Pick: func(w http.ResponseWriter, r *http.Request) Selection {
+				return Selection{Color: "blue", Generation: 1, Faults: tt.faults}
+			},
+			Faults: faults.NewDecider(func(n int) int { return 0 }),
+			Sleep: func(ctx context.Context, name string, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return tt.sleep
+			},
+			ZoneLatency: tt.zoneLatency,
+			Upstream: func(w http.ResponseWriter, r *http.Request, color string, status int) (int, bool) {
+				if tt.upstream == 0 {
+					return status, false
+				}
+				return tt.upstream, true
+			},
+			OnServed: func(w http.ResponseWriter, r *http.Request, color string, status int) {
+				served = append(served, status)
+			},
+			Headers: map[string]http.Header{"blue": {"X-Color-Tier": {"stable"}}},
+		})
+		method := tt.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		r := httptest.NewRequest(method, "/color", nil)
+		if tt.body != "" {
+			r = httptest.NewRequest(method, "/color", strings.NewReader(tt.body))
+		}
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if got := w.Body.String(); got != tt.wantBody {
+			t.Errorf("%s: got body %q, want %q", tt.name, got, tt.wantBody)
+		}
+		if !reflect.DeepEqual(sleeps, tt.wantSleeps) {
+			t.Errorf("%s: got delays %v, want %v", tt.name, sleeps, tt.wantSleeps)
+		}
+		if tt.wantBody == `"blue"` {
+			if !reflect.DeepEqual(served, []int{tt.wantStatus}) {
+				t.Errorf("%s: got served statuses %v, want [%d]", tt.name, served, tt.wantStatus)
+			}
+			if got := w.Header().Get("X-Color-Tier"); got != "stable" {
+				t.Errorf("%s: got X-Color-Tier %q, want stable", tt.name, got)
+			}
+		}
+		if etag := w.Header().Get("ETag"); etag != "" && (tt.wantStatus == http.StatusInternalServerError || tt.upstream != 0) {
+			t.Errorf("%s: got ETag %q on a failed or proxied response", tt.name, etag)
+		}
+	}
+}
+
+func TestColorNotModified(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	generation := uint64(1)
+	var served []int
+	c := NewColor(ColorConfig{
+		Pick: func(w http.ResponseWriter, r *http.Request) Selection {
+			return Selection{Color: "blue", Generation: generation}
+		},
+		OnServed: func(w http.ResponseWriter, r *http.Request, color string, status int) {
+			served = append(served, status)
+		},
+	})
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/color", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		return w
+	}
+
+	etag := get(nil).Header().Get("ETag")
+	if w := get(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got status %d and body %q, want an empty 304", w.Code, w.Body.String())
+	}
+	if w := get(http.Header{"If-None-Match": {"W/" + etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for the weak ETag, want 304", w.Code)
+	}
+	if w := get(http.Header{"If-None-Match": {etag}, "Accept": {"application/xml"}}); w.Code != http.StatusOK {
+		t.Errorf("got status %d for another media type, want 200", w.Code)
+	}
+	generation++
+	if w := get(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("got status %d after the settings changed, want 200", w.Code)
+	}
+	if want := []int{200, 304, 304, 200, 200}; !reflect.DeepEqual(served, want) {
+		t.Errorf("got served statuses %v, want %v", served, want)
+	}
+}
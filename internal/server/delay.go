@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrDraining is returned by the injected delays when the servers start draining
+var ErrDraining = errors.New("server shutting down")
+
+// sleep sleeps for the duration, returning early with an error when the context is done
+func sleep(ctx context.Context, _ string, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DelayAborted replies to a request whose injected delay was cut short: with a 503 when the servers
+// are draining, and not at all when the client went away
+func DelayAborted(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Aborted the delay of %s: %v", r.URL.Path, err)
+	if err == ErrDraining {
+		WriteError(w, r, http.StatusServiceUnavailable, err.Error())
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// protoMessager is implemented by the responses which can be encoded as protobuf
+type protoMessager interface {
+	protoMessage() proto.Message
+}
+
+// encoder encodes responses of a given media type. Encoders which can't represent a value return
+// false from supports, and the next acceptable encoder is used.
+type encoder struct {
+	mediaType string
+	// contentType is the value of the Content-Type header, shared by the responses
+	contentType []string
+	supports    func(v interface{}) bool
+	encode      func(w io.Writer, v interface{}) error
+}
+
+// encoders are the supported response encodings, the first one being the default
+var encoders = []encoder{
+	{
+		mediaType:   "application/json",
+		contentType: []string{"application/json"},
+		supports:    func(v interface{}) bool { return true },
+		encode: func(w io.Writer, v interface{}) error {
+			if c, ok := v.(*ColorResponse); ok && c.json != nil {
+				_, err := w.Write(c.json)
+				return err
+			}
+			buf := getBuffer()
+			defer putBuffer(buf)
+			if err := json.NewEncoder(buf).Encode(v); err != nil {
+				return err
+			}
+			// unlike json.Marshal, the encoder terminates the value with a newline
+			_, err := w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+			return err
+		},
+	},
+	{
+		mediaType:   "application/xml",
+		contentType: []string{"application/xml; charset=utf-8"},
+		supports:    func(v interface{}) bool { return true },
+		encode:      encodeXML,
+	},
+	{
+		mediaType:   "text/xml",
+		contentType: []string{"text/xml; charset=utf-8"},
+		supports:    func(v interface{}) bool { return true },
+		encode:      encodeXML,
+	},
+	{
+		mediaType:   "text/plain",
+		contentType: []string{"text/plain; charset=utf-8"},
+		supports: func(v interface{}) bool {
+			_, ok := v.(fmt.Stringer)
+			return ok
+		},
+		encode: func(w io.Writer, v interface{}) error {
+			_, err := io.WriteString(w, v.(fmt.Stringer).String())
+			return err
+		},
+	},
+	{
+		mediaType:   "application/x-protobuf",
+		contentType: []string{"application/x-protobuf"},
+		supports: func(v interface{}) bool {
+			_, ok := v.(protoMessager)
+			return ok
+		},
+		encode: func(w io.Writer, v interface{}) error {
+			body, err := proto.Marshal(v.(protoMessager).protoMessage())
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(body)
+			return err
+		},
+	},
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if c, ok := v.(*ColorResponse); ok && c.xml != nil {
+		_, err := w.Write(c.xml)
+		return err
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// maxPooledBuffer bounds the size of the buffers kept in the pool, so a large response doesn't pin
+// its memory for the small ones
+const maxPooledBuffer = 64 << 10
+
+// bufferPool recycles the buffers the responses are encoded in, saving their allocation on every
+// request
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+type acceptedType struct {
+	mediaType string
+	q         float64
+}
+
+// nextToken returns the text up to the separator and the text after it, or the whole text if it has
+// no separator
+func nextToken(s string, sep byte) (token, rest string) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// parseAcceptEntry parses an entry of an Accept header, e.g. "application/xml;q=0.9". The header
+// is scanned in place rather than split, so negotiating doesn't allocate.
+func parseAcceptEntry(entry string) acceptedType {
+	mediaType, params := nextToken(entry, ';')
+	accepted := acceptedType{mediaType: strings.ToLower(strings.TrimSpace(mediaType)), q: 1}
+	for params != "" {
+		var param string
+		param, params = nextToken(params, ';')
+		name, value := nextToken(param, '=')
+		if strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				accepted.q = q
+			}
+		}
+	}
+	return accepted
+}
+
+func (a acceptedType) matches(mediaType string) bool {
+	if a.mediaType == "*/*" || a.mediaType == mediaType {
+		return true
+	}
+	return strings.HasSuffix(a.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*"))
+}
+
+// negotiate returns the encoder of the most preferred media type which can represent the value, or
+// nil if none of the accepted media types can. Among the media types of the same preference, the
+// first one listed wins.
+func negotiate(r *http.Request, v interface{}) *encoder {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return &encoders[0]
+	}
+	var best *encoder
+	var bestQ float64
+	for header != "" {
+		var entry string
+		entry, header = nextToken(header, ',')
+		accepted := parseAcceptEntry(entry)
+		if accepted.mediaType == "" || accepted.q <= bestQ {
+			continue
+		}
+		for i := range encoders {
+			if accepted.matches(encoders[i].mediaType) && encoders[i].supports(v) {
+				best, bestQ = &encoders[i], accepted.q
+				break
+			}
+		}
+	}
+	return best
+}
+
+// The header values set on every response, assigned rather than set with http.Header.Set and Add,
+// which allocate a slice of values on every call. The shared values must not be modified.
+var (
+	varyAccept = []string{"Accept"}
+	nosniff    = []string{"nosniff"}
+)
+
+// addHeader adds the shared values to the header, which key must be canonical, copying them only
+// when the header is already set
+func addHeader(h http.Header, key string, values []string) {
+	if existing := h[key]; len(existing) > 0 {
+		h[key] = append(existing[:len(existing):len(existing)], values...)
+		return
+	}
+	h[key] = values
+}
+
+// WriteResponse writes the value encoded in the media type negotiated with the client
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	addHeader(w.Header(), "Vary", varyAccept)
+	enc := negotiate(r, v)
+	if enc == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotAcceptable)
+		fmt.Fprintf(w, "none of the accepted media types is supported: %s", r.Header.Get("Accept"))
+		return
+	}
+	w.Header()["Content-Type"] = enc.contentType
+	w.Header()["X-Content-Type-Options"] = nosniff
+	w.WriteHeader(status)
+	if err := enc.encode(w, v); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// errorResponse is the body of the error responses
+type errorResponse struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Message string   `json:"error" xml:",chardata"`
+}
+
+func (e errorResponse) String() string {
+	return e.Message
+}
+
+func (e errorResponse) protoMessage() proto.Message {
+	return wrapperspb.String(e.Message)
+}
+
+// WriteError writes an error message encoded in the media type negotiated with the client
+func WriteError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	WriteResponse(w, r, status, errorResponse{Message: message})
+}
+
+// ColorResponse is the body of the color responses: a JSON string (e.g. "blue"), <color>blue</color>
+// in XML, the plain color name in text, and a StringValue in protobuf
+type ColorResponse struct {
+	XMLName xml.Name `xml:"color"`
+	Color   string   `xml:",chardata"`
+	// json and xml are the preformatted encodings of the cached responses, written as is
+	json []byte
+	xml  []byte
+}
+
+// preformattedColor returns the response of the color along with its JSON and XML encodings
+func preformattedColor(color string) *ColorResponse {
+	c := &ColorResponse{Color: color}
+	c.json, _ = json.Marshal(color)
+	var buf bytes.Buffer
+	if err := encodeXML(&buf, c); err == nil {
+		c.xml = buf.Bytes()
+	}
+	return c
+}
+
+func (c ColorResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Color)
+}
+
+func (c ColorResponse) String() string {
+	return c.Color
+}
+
+func (c ColorResponse) protoMessage() proto.Message {
+	return wrapperspb.String(c.Color)
+}
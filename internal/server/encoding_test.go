@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		value  interface{}
+		// want is the negotiated media type, empty when none of the accepted ones is supported
+		want string
+	}{
+		{name: "no Accept header", value: ColorResponse{}, want: "application/json"},
+		{name: "any", accept: "*/*", value: ColorResponse{}, want: "application/json"},
+		{name: "text", accept: "text/*", value: ColorResponse{}, want: "text/xml"},
+		{name: "preferred", accept: "application/json;q=0.5, text/plain", value: ColorResponse{}, want: "text/plain"},
+		{name: "first of the same preference", accept: "application/xml, application/json", value: ColorResponse{}, want: "application/xml"},
+		{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", value: ColorResponse{}, want: "application/xml"},
+		{name: "protobuf", accept: "application/x-protobuf", value: ColorResponse{}, want: "application/x-protobuf"},
+		{name: "unsupported by the value", accept: "application/x-protobuf", value: struct{}{}},
+		{name: "not acceptable", accept: "image/png", value: ColorResponse{}},
+		{name: "refused", accept: "application/json;q=0", value: ColorResponse{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/color", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		var got string
+		if enc := negotiate(r, tt.value); enc != nil {
+			got = enc.mediaType
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkEncode compares the encoders of the responses, writing the preformatted colors and
+// encoding the other values in pooled buffers, against marshalling every response anew
+func BenchmarkEncode(b *testing.B) {
+	color := preformattedColor("blue")
+	errResp := errorResponse{Message: "injected error"}
+	jsonEncoder, xmlEncoder := encoders[0], encoders[1]
+
+	benchmarks := []struct {
+		name   string
+		encode func() error
+	}{
+		{"color/json/preformatted", func() error { return jsonEncoder.encode(io.Discard, color) }},
+		{"color/json/marshal", func() error { return marshal(json.Marshal, color) }},
+		{"error/json/pooled", func() error { return jsonEncoder.encode(io.Discard, errResp) }},
+		{"error/json/marshal", func() error { return marshal(json.Marshal, errResp) }},
+		{"color/xml/preformatted", func() error { return xmlEncoder.encode(io.Discard, color) }},
+		{"color/xml/marshal", func() error { return marshal(xml.Marshal, color) }},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := bm.encode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// marshal is the baseline the encoders are measured against
+func marshal(marshal func(v interface{}) ([]byte, error), v interface{}) error {
+	body, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.Discard.Write(body)
+	return err
+}
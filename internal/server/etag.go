@@ -0,0 +1,80 @@
+package server
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxCachedColors bounds the number of cached color responses and entity tags, as the overrides can
+// be any valid color name
+const maxCachedColors = 256
+
+// etagKey identifies the entity tag of a color response
+type etagKey struct {
+	color      string
+	generation uint64
+	mediaType  string
+}
+
+// etagCache caches the ETag header values of the color responses, so the ones of the served colors
+// are not allocated again on every request. The cache is reset when full, dropping the tags of the
+// previous settings generations.
+type etagCache struct {
+	mu     sync.RWMutex
+	values map[etagKey][]string
+}
+
+// header returns the ETag header value of the color response
+func (c *etagCache) header(r *http.Request, color string, generation uint64) []string {
+	key := etagKey{color: color, generation: generation}
+	if enc := negotiate(r, ColorResponse{}); enc != nil {
+		key.mediaType = enc.mediaType
+	}
+	c.mu.RLock()
+	value := c.values[key]
+	c.mu.RUnlock()
+	if value != nil {
+		return value
+	}
+	value = []string{colorETag(key)}
+	c.mu.Lock()
+	if c.values == nil || len(c.values) >= maxCachedColors {
+		c.values = make(map[etagKey][]string)
+	}
+	c.values[key] = value
+	c.mu.Unlock()
+	return value
+}
+
+// colorETag computes the entity tag of a color response from the color, the settings generation
+// and the negotiated media type, so it changes whenever the color or the configuration changes
+func colorETag(key etagKey) string {
+	color, generation, mediaType := key.color, key.generation, key.mediaType
+	var buf [64]byte
+	b := append(buf[:0], color...)
+	b = append(b, 0)
+	b = strconv.AppendUint(b, generation, 10)
+	b = append(b, 0)
+	b = append(b, mediaType...)
+	h := fnv.New64a()
+	h.Write(b)
+	b = append(buf[:0], '"')
+	b = strconv.AppendUint(b, h.Sum64(), 16)
+	return string(append(b, '"'))
+}
+
+// etagMatches returns whether the If-None-Match header matches the entity tag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for ifNoneMatch != "" {
+		var candidate string
+		candidate, ifNoneMatch = nextToken(ifNoneMatch, ',')
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
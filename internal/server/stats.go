@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/xml"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// statsInterval is the duration of the intervals the /color responses are aggregated in
+	statsInterval = 5 * time.Second
+	// statsIntervals is the number of intervals returned by /stats, covering the last 5 minutes
+	statsIntervals = 60
+	// statsMinLatencyMs is the upper bound of the first latency bin of the histograms
+	statsMinLatencyMs = 0.1
+	// statsBinGrowth is the ratio between the bounds of consecutive latency bins, so the percentiles
+	// are reported within 10%
+	statsBinGrowth = 1.1
+	// statsBins is the number of latency bins of the histograms, the last one counting all the
+	// latencies above 10 minutes
+	statsBins = 166
+)
+
+// statsBucket aggregates the /color responses of an interval. The latencies are counted in a fixed
+// size histogram with exponential bins, so a busy interval doesn't grow the memory used.
+type statsBucket struct {
+	start    time.Time
+	requests int
+	errors   int
+	maxMs    float64
+	counts   [statsBins]uint32
+}
+
+// statsBin returns the index of the histogram bin counting the given latency
+func statsBin(latencyMs float64) int {
+	if latencyMs <= statsMinLatencyMs {
+		return 0
+	}
+	i := 1 + int(math.Log(latencyMs/statsMinLatencyMs)/math.Log(statsBinGrowth))
+	if i >= statsBins {
+		return statsBins - 1
+	}
+	return i
+}
+
+// Stats returns the p50 and p99 latency and the error rate of the /color responses of the last 5
+// minutes, in 5 seconds intervals, so the UI can chart the latency and error rate of the rollout
+// without Grafana
+type Stats struct {
+	mu      sync.Mutex
+	buckets []*statsBucket
+}
+
+// NewStats returns a stats handler without any recorded response
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// Record adds a served /color response to the interval it completed in
+func (s *Stats) Record(status int, latencyMs float64) {
+	start := time.Now().Truncate(statsInterval)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bucket *statsBucket
+	if n := len(s.buckets); n > 0 && s.buckets[n-1].start.Equal(start) {
+		bucket = s.buckets[n-1]
+	} else {
+		bucket = &statsBucket{start: start}
+		s.buckets = append(s.buckets, bucket)
+		if len(s.buckets) > statsIntervals {
+			s.buckets = s.buckets[len(s.buckets)-statsIntervals:]
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		bucket.errors++
+	}
+	bucket.requests++
+	bucket.counts[statsBin(latencyMs)]++
+	if latencyMs > bucket.maxMs {
+		bucket.maxMs = latencyMs
+	}
+}
+
+// statsPoint is the latency and error rate of the /color responses of an interval
+type statsPoint struct {
+	Time      time.Time `json:"time" xml:"time,attr"`
+	Requests  int       `json:"requests" xml:"requests"`
+	ErrorRate float64   `json:"errorRate" xml:"errorRate"`
+	P50Ms     float64   `json:"p50Ms" xml:"p50Ms"`
+	P99Ms     float64   `json:"p99Ms" xml:"p99Ms"`
+}
+
+// statsResponse is the body of the stats responses, with a point for each of the last intervals,
+// including the ones without requests
+type statsResponse struct {
+	XMLName         xml.Name     `json:"-" xml:"stats"`
+	IntervalSeconds float64      `json:"intervalSeconds" xml:"intervalSeconds,attr"`
+	Points          []statsPoint `json:"points" xml:"point"`
+}
+
+// points returns the points of the last intervals, ending with the current one
+func (s *Stats) points(now time.Time) []statsPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	points := make([]statsPoint, statsIntervals)
+	end := now.Truncate(statsInterval)
+	for i := range points {
+		points[i].Time = end.Add(-time.Duration(statsIntervals-1-i) * statsInterval)
+	}
+	for _, bucket := range s.buckets {
+		i := statsIntervals - 1 - int(end.Sub(bucket.start)/statsInterval)
+		if i < 0 || i >= statsIntervals {
+			continue
+		}
+		points[i].Requests = bucket.requests
+		points[i].ErrorRate = float64(bucket.errors) / float64(bucket.requests)
+		points[i].P50Ms = bucket.percentile(0.5)
+		points[i].P99Ms = bucket.percentile(0.99)
+	}
+	return points
+}
+
+// percentile returns the given percentile of the latencies, using the nearest rank: the upper bound
+// of the bin holding it, capped by the highest latency of the interval, which is also returned for
+// the unbounded last bin
+func (b *statsBucket) percentile(p float64) float64 {
+	if b.requests == 0 {
+		return 0
+	}
+	rank := int(p*float64(b.requests) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int
+	for i, count := range b.counts {
+		if seen += int(count); seen >= rank && i < statsBins-1 {
+			return math.Min(statsMinLatencyMs*math.Pow(statsBinGrowth, float64(i)), b.maxMs)
+		}
+	}
+	return b.maxMs
+}
+
+func (s *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	WriteResponse(w, r, http.StatusOK, statsResponse{
+		IntervalSeconds: statsInterval.Seconds(),
+		Points:          s.points(time.Now()),
+	})
+}
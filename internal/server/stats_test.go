@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestColorStatsPoints(t *testing.T) {
+	// keep the requests in a single interval
+	if wait := time.Until(time.Now().Truncate(statsInterval).Add(statsInterval)); wait < time.Second {
+		time.Sleep(wait)
+	}
+	s := NewStats()
+	// 1 to 1000 ms, one of every hundred requests failing
+	for i := 1; i <= 1000; i++ {
+		status := http.StatusOK
+		if i%100 == 0 {
+			status = http.StatusInternalServerError
+		}
+		s.Record(status, float64(i))
+	}
+
+	points := s.points(time.Now())
+	if len(points) != statsIntervals {
+		t.Fatalf("got %d points, want %d", len(points), statsIntervals)
+	}
+	last := points[len(points)-1]
+	if last.Requests != 1000 {
+		t.Errorf("got %d requests, want 1000", last.Requests)
+	}
+	if last.ErrorRate != 0.01 {
+		t.Errorf("got error rate %v, want 0.01", last.ErrorRate)
+	}
+	for _, tt := range []struct {
+		name string
+		got  float64
+		want float64
+	}{{"p50", last.P50Ms, 500}, {"p99", last.P99Ms, 990}} {
+		if math.Abs(tt.got-tt.want) > tt.want*(statsBinGrowth-1) {
+			t.Errorf("got %s %vms, want %vms within 10%%", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestStatsBucketPercentile(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []float64
+		p         float64
+		want      float64
+	}{
+		{name: "no request", p: 0.5, want: 0},
+		{name: "single request", latencies: []float64{42.5}, p: 0.99, want: 42.5},
+		{name: "sub-millisecond", latencies: []float64{0.05, 0.08}, p: 0.5, want: 0.08},
+		{name: "above the last bin", latencies: []float64{1, time.Hour.Seconds() * 1000}, p: 0.99, want: time.Hour.Seconds() * 1000},
+	}
+	for _, tt := range tests {
+		var b statsBucket
+		for _, latency := range tt.latencies {
+			b.requests++
+			b.counts[statsBin(latency)]++
+			b.maxMs = math.Max(b.maxMs, latency)
+		}
+		if got := b.percentile(tt.p); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	s := NewStats()
+	s.Record(http.StatusOK, 10)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	var response struct {
+		IntervalSeconds float64      `json:"intervalSeconds"`
+		Points          []statsPoint `json:"points"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.IntervalSeconds != statsInterval.Seconds() || len(response.Points) != statsIntervals {
+		t.Errorf("got %v points of %vs, want %d points of %vs", len(response.Points), response.IntervalSeconds, statsIntervals, statsInterval.Seconds())
+	}
+	var requests int
+	for _, p := range response.Points {
+		requests += p.Requests
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
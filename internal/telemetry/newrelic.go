@@ -0,0 +1,178 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// NewRelic starts the New Relic agent, reporting through the given transport. It is configured
+// with the NEW_RELIC_LICENSE_KEY and NEW_RELIC_LABELS environment variables, or entirely from the
+// NEW_RELIC_* environment variables with NEW_RELIC_USE_ENV_CONFIG=true. The expand function is
+// applied to the application name and the label values, e.g. to fill in the pod metadata.
+func NewRelic(transport http.RoundTripper, appName string, expand func(string) string) (Provider, error) {
+	withTransport := func(cfg *newrelic.Config) {
+		cfg.Transport = transport
+	}
+	withExpansion := func(cfg *newrelic.Config) {
+		cfg.AppName = expand(cfg.AppName)
+		for key, value := range cfg.Labels {
+			cfg.Labels[key] = expand(value)
+		}
+	}
+
+	var app *newrelic.Application
+	var err error
+	useEnvConfig := os.Getenv("NEW_RELIC_USE_ENV_CONFIG")
+	if useEnvConfig == "true" {
+		app, err = newrelic.NewApplication(newrelic.ConfigFromEnvironment(), withTransport, withExpansion)
+	} else {
+		app, err = newrelic.NewApplication(
+			newrelic.ConfigDebugLogger(os.Stdout),
+			newrelic.ConfigEnabled(true),
+			newrelic.ConfigDistributedTracerEnabled(true),
+			newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
+			newrelic.ConfigAppName(appName),
+			withTransport,
+			func(cfg *newrelic.Config) {
+				cfg.ErrorCollector.Enabled = true
+				cfg.ErrorCollector.RecordPanics = true
+				cfg.ErrorCollector.CaptureEvents = true
+				cfg.ErrorCollector.Attributes.Enabled = true
+				cfg.TransactionTracer.Enabled = true
+				cfg.TransactionTracer.Attributes.Enabled = true
+				cfg.CustomInsightsEvents.Enabled = true
+				cfg.Utilization.DetectKubernetes = true
+
+				if envLabels := os.Getenv("NEW_RELIC_LABELS"); envLabels != "" {
+					if labels := getLabels(envLabels); len(labels) > 0 {
+						cfg.Labels = labels
+					} else {
+						cfg.Error = fmt.Errorf("invalid NEW_RELIC_LABELS value: %s", envLabels)
+					}
+				}
+			},
+			withExpansion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &newRelicProvider{app: app}, nil
+}
+
+func getLabels(env string) map[string]string {
+	out := make(map[string]string)
+	env = strings.Trim(env, ";\t\n\v\f\r ")
+	for _, entry := range strings.Split(env, ";") {
+		if entry == "" {
+			return nil
+		}
+		split := strings.Split(entry, ":")
+		if len(split) != 2 {
+			return nil
+		}
+		left := strings.TrimSpace(split[0])
+		right := strings.TrimSpace(split[1])
+		if left == "" || right == "" {
+			return nil
+		}
+		if utf8.RuneCountInString(left) > 255 {
+			runes := []rune(left)
+			left = string(runes[:255])
+		}
+		if utf8.RuneCountInString(right) > 255 {
+			runes := []rune(right)
+			right = string(runes[:255])
+		}
+		out[left] = right
+		if len(out) >= 64 {
+			return out
+		}
+	}
+	return out
+}
+
+type newRelicProvider struct {
+	app *newrelic.Application
+}
+
+func (p *newRelicProvider) WrapHandler(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	_, wrapped := newrelic.WrapHandleFunc(p.app, pattern, func(w http.ResponseWriter, r *http.Request) {
+		txn := &newRelicTransaction{txn: newrelic.FromContext(r.Context())}
+		handler(w, r.WithContext(NewContext(r.Context(), txn)))
+	})
+	return wrapped
+}
+
+func (p *newRelicProvider) StartTransaction(ctx context.Context, name string, header http.Header) (context.Context, Transaction) {
+	txn := &newRelicTransaction{txn: p.app.StartTransaction(name)}
+	txn.txn.AcceptDistributedTraceHeaders(newrelic.TransportOther, header)
+	return NewContext(ctx, txn), txn
+}
+
+func (p *newRelicProvider) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return newrelic.NewRoundTripper(next)
+}
+
+// shutdownTimeout bounds the New Relic shutdown when the context has no deadline
+const shutdownTimeout = 10 * time.Second
+
+// Shutdown sends the pending data to New Relic before disconnecting
+func (p *newRelicProvider) Shutdown(ctx context.Context) error {
+	timeout := shutdownTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	p.app.Shutdown(timeout)
+	return nil
+}
+
+type newRelicTransaction struct {
+	txn *newrelic.Transaction
+}
+
+func (t *newRelicTransaction) AddAttribute(key string, value interface{}) {
+	t.txn.AddAttribute(key, value)
+}
+
+func (t *newRelicTransaction) NoticeError(err error, class string, attributes map[string]interface{}) {
+	t.txn.NoticeError(newrelic.Error{
+		Message:    err.Error(),
+		Class:      class,
+		Attributes: attributes,
+	})
+}
+
+func (t *newRelicTransaction) StartSegment(name string) Segment {
+	return t.txn.StartSegment(name)
+}
+
+func (t *newRelicTransaction) InjectHeaders(header http.Header) {
+	t.txn.InsertDistributedTraceHeaders(header)
+}
+
+func (t *newRelicTransaction) NewGoroutine() Transaction {
+	return &newRelicTransaction{txn: t.txn.NewGoroutine()}
+}
+
+func (t *newRelicTransaction) BrowserTimingHeader() ([]byte, error) {
+	header := t.txn.BrowserTimingHeader()
+	if header == nil {
+		return nil, nil
+	}
+	return header.WithTags(), nil
+}
+
+func (t *newRelicTransaction) End() {
+	t.txn.End()
+}
+
+func (t *newRelicTransaction) newContext(ctx context.Context) context.Context {
+	return newrelic.NewContext(ctx, t.txn)
+}
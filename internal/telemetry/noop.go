@@ -0,0 +1,44 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+)
+
+// Noop returns a provider tracing nothing, used when APM is disabled
+func Noop() Provider {
+	return noopProvider{}
+}
+
+type noopProvider struct{}
+
+func (noopProvider) WrapHandler(_ string, handler http.HandlerFunc) http.HandlerFunc {
+	return handler
+}
+
+func (noopProvider) StartTransaction(ctx context.Context, _ string, _ http.Header) (context.Context, Transaction) {
+	return ctx, noopTransaction{}
+}
+
+func (noopProvider) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return next
+}
+
+func (noopProvider) Shutdown(context.Context) error {
+	return nil
+}
+
+type noopTransaction struct{}
+
+func (noopTransaction) AddAttribute(string, interface{})                  {}
+func (noopTransaction) NoticeError(error, string, map[string]interface{}) {}
+func (noopTransaction) StartSegment(string) Segment                       { return noopSegment{} }
+func (noopTransaction) InjectHeaders(http.Header)                         {}
+func (noopTransaction) NewGoroutine() Transaction                         { return noopTransaction{} }
+func (noopTransaction) BrowserTimingHeader() ([]byte, error)              { return nil, nil }
+func (noopTransaction) End()                                              {}
+func (noopTransaction) newContext(ctx context.Context) context.Context    { return ctx }
+
+type noopSegment struct{}
+
+func (noopSegment) End() {}
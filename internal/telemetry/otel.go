@@ -0,0 +1,223 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer creating the OpenTelemetry spans
+const instrumentationName = "github.com/argoproj/rollouts-demo"
+
+// OpenTelemetry exports the traces to an OTLP/HTTP endpoint through the given transport, configured
+// with the standard OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME environment variables. The trace
+// context is propagated with the W3C trace context and baggage headers.
+func OpenTelemetry(ctx context.Context, transport http.RoundTripper) (Provider, error) {
+	client, err := newOTLPClient(transport)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the OTLP exporter: %v", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "rollouts-demo")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost())
+	if err != nil {
+		return nil, fmt.Errorf("could not create the OpenTelemetry resource: %v", err)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	otel.SetTextMapPropagator(propagator)
+	return &otelProvider{
+		tracerProvider: tracerProvider,
+		tracer:         tracerProvider.Tracer(instrumentationName),
+		propagator:     propagator,
+	}, nil
+}
+
+type otelProvider struct {
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+	propagator     propagation.TextMapPropagator
+}
+
+// statusWriter captures the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (p *otelProvider) WrapHandler(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := p.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := p.tracer.Start(ctx, pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.RequestURI()),
+				attribute.String("http.route", pattern)))
+		defer span.End()
+		txn := &otelTransaction{ctx: ctx, span: span, provider: p}
+		rec := &statusWriter{ResponseWriter: w}
+		handler(rec, r.WithContext(NewContext(ctx, txn)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
+
+func (p *otelProvider) StartTransaction(ctx context.Context, name string, header http.Header) (context.Context, Transaction) {
+	ctx = p.propagator.Extract(ctx, propagation.HeaderCarrier(header))
+	ctx, span := p.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	txn := &otelTransaction{ctx: ctx, span: span, provider: p}
+	return NewContext(ctx, txn), txn
+}
+
+func (p *otelProvider) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &otelRoundTripper{next: next, provider: p}
+}
+
+// Shutdown exports the spans still batched before stopping the exporter
+func (p *otelProvider) Shutdown(ctx context.Context) error {
+	return p.tracerProvider.Shutdown(ctx)
+}
+
+// otelRoundTripper traces the outbound requests in client spans
+type otelRoundTripper struct {
+	next     http.RoundTripper
+	provider *otelProvider
+}
+
+func (t *otelRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.provider.tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String())))
+	defer span.End()
+	req = req.Clone(ctx)
+	t.provider.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+type otelTransaction struct {
+	ctx      context.Context
+	span     trace.Span
+	provider *otelProvider
+}
+
+// otelAttribute converts a custom attribute value to an OpenTelemetry attribute
+func otelAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
+
+func (t *otelTransaction) AddAttribute(key string, value interface{}) {
+	t.span.SetAttributes(otelAttribute(key, value))
+}
+
+func (t *otelTransaction) NoticeError(err error, class string, attributes map[string]interface{}) {
+	kvs := []attribute.KeyValue{attribute.String("exception.type", class)}
+	for key, value := range attributes {
+		kvs = append(kvs, otelAttribute(key, value))
+	}
+	t.span.RecordError(err, trace.WithAttributes(kvs...))
+	t.span.SetStatus(codes.Error, err.Error())
+}
+
+func (t *otelTransaction) StartSegment(name string) Segment {
+	_, span := t.provider.tracer.Start(t.ctx, name)
+	return otelSegment{span: span}
+}
+
+func (t *otelTransaction) InjectHeaders(header http.Header) {
+	t.provider.propagator.Inject(t.ctx, propagation.HeaderCarrier(header))
+}
+
+// NewGoroutine returns the transaction itself, as spans can be used concurrently
+func (t *otelTransaction) NewGoroutine() Transaction {
+	return t
+}
+
+func (t *otelTransaction) BrowserTimingHeader() ([]byte, error) {
+	return nil, nil
+}
+
+func (t *otelTransaction) End() {
+	t.span.End()
+}
+
+func (t *otelTransaction) newContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, t.span)
+}
+
+type otelSegment struct {
+	span trace.Span
+}
+
+func (s otelSegment) End() {
+	s.span.End()
+}
@@ -0,0 +1,185 @@
+package telemetry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// otlpDefaultEndpoint is the endpoint the spans are sent to when none is configured
+	otlpDefaultEndpoint = "https://localhost:4318"
+	// otlpTracesPath is the path of the traces relative to OTEL_EXPORTER_OTLP_ENDPOINT
+	otlpTracesPath = "/v1/traces"
+	// otlpDefaultTimeout bounds each upload when OTEL_EXPORTER_OTLP_TIMEOUT is not set
+	otlpDefaultTimeout = 10 * time.Second
+)
+
+// otlpClient uploads the spans to an OTLP/HTTP endpoint through the given transport, so the exporter
+// honors the proxy and TLS settings of the APM connections. The OTLP/HTTP exporter of the SDK can't
+// be given a transport, so this client reads the standard OTEL_EXPORTER_OTLP_* environment variables
+// itself: the endpoint, headers, compression and timeout, the traces specific ones taking precedence.
+// Failed uploads are reported to the OpenTelemetry error handler and not retried.
+type otlpClient struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+	gzip    bool
+}
+
+func newOTLPClient(transport http.RoundTripper) (*otlpClient, error) {
+	c := &otlpClient{url: otlpDefaultEndpoint + otlpTracesPath}
+	if endpoint := otlpEnv("TRACES_ENDPOINT"); endpoint != "" {
+		u, err := parseOTLPEndpoint("TRACES_ENDPOINT", endpoint)
+		if err != nil {
+			return nil, err
+		}
+		// the traces endpoint is used as is, the root standing for an empty path
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		c.url = u.String()
+	} else if endpoint := otlpEnv("ENDPOINT"); endpoint != "" {
+		u, err := parseOTLPEndpoint("ENDPOINT", endpoint)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = path.Join(u.Path, otlpTracesPath)
+		c.url = u.String()
+	}
+
+	headers := otlpEnv("TRACES_HEADERS")
+	if headers == "" {
+		headers = otlpEnv("HEADERS")
+	}
+	var err error
+	if c.headers, err = parseOTLPHeaders(headers); err != nil {
+		return nil, err
+	}
+
+	compression := otlpEnv("TRACES_COMPRESSION")
+	if compression == "" {
+		compression = otlpEnv("COMPRESSION")
+	}
+	switch compression {
+	case "", "none":
+	case "gzip":
+		c.gzip = true
+	default:
+		return nil, fmt.Errorf("invalid OTLP compression %q, expected gzip or none", compression)
+	}
+
+	timeout := otlpDefaultTimeout
+	value := otlpEnv("TRACES_TIMEOUT")
+	if value == "" {
+		value = otlpEnv("TIMEOUT")
+	}
+	if value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("invalid OTLP timeout %q, expected a number of milliseconds", value)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	c.client = &http.Client{Transport: transport, Timeout: timeout}
+	return c, nil
+}
+
+// otlpEnv returns the value of the OTEL_EXPORTER_OTLP_<name> environment variable
+func otlpEnv(name string) string {
+	return strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_" + name))
+}
+
+func parseOTLPEndpoint(name, endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_%s value %q, expected an http or https URL", name, endpoint)
+	}
+	return u, nil
+}
+
+// parseOTLPHeaders parses a comma separated list of URL encoded key=value headers
+func parseOTLPHeaders(list string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		split := strings.SplitN(entry, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected <key>=<value>", entry)
+		}
+		key, keyErr := url.QueryUnescape(strings.TrimSpace(split[0]))
+		value, valueErr := url.QueryUnescape(strings.TrimSpace(split[1]))
+		if keyErr != nil || valueErr != nil || key == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected <key>=<value>", entry)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// Start does nothing, the connections being opened by the uploads
+func (c *otlpClient) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop closes the idle connections, the uploads in flight being bounded by their context
+func (c *otlpClient) Stop(ctx context.Context) error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// UploadTraces sends a batch of spans to the endpoint
+func (c *otlpClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	body, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	if c.gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if c.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to send the traces to %s: %s", c.url, resp.Status)
+	}
+	return nil
+}
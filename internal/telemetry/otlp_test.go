@@ -0,0 +1,89 @@
+package telemetry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPClientUploadsThroughTransport(t *testing.T) {
+	var proxied *http.Request
+	var spans int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r
+		body, _ := ioutil.ReadAll(r.Body)
+		var request coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &request); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+		spans = len(request.ResourceSpans)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector.example.com:4318/otlp")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret%3D,x-team=demo")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+
+	client, err := newOTLPClient(&http.Transport{Proxy: http.ProxyURL(proxyURL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UploadTraces(context.Background(), []*tracepb.ResourceSpans{{}}); err != nil {
+		t.Fatal(err)
+	}
+	if proxied == nil {
+		t.Fatal("the spans were not sent through the proxy of the transport")
+	}
+	if got, want := proxied.URL.String(), "http://collector.example.com:4318/otlp/v1/traces"; got != want {
+		t.Errorf("got URL %s, want %s", got, want)
+	}
+	if got := proxied.Header.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("got Content-Type %s, want application/x-protobuf", got)
+	}
+	if got := proxied.Header.Get("Api-Key"); got != "secret=" {
+		t.Errorf("got api-key header %q, want secret=", got)
+	}
+	if spans != 1 {
+		t.Errorf("got %d resource spans, want 1", spans)
+	}
+}
+
+func TestNewOTLPClientEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint       string
+		tracesEndpoint string
+		want           string
+		wantErr        bool
+	}{
+		{want: "https://localhost:4318/v1/traces"},
+		{endpoint: "http://collector:4318", want: "http://collector:4318/v1/traces"},
+		{endpoint: "http://collector:4318", tracesEndpoint: "https://traces.example.com", want: "https://traces.example.com/"},
+		{tracesEndpoint: "https://traces.example.com/custom", want: "https://traces.example.com/custom"},
+		{endpoint: "collector:4318", wantErr: true},
+		{tracesEndpoint: "ftp://traces.example.com", wantErr: true},
+	}
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	for _, tt := range tests {
+		os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.endpoint)
+		os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", tt.tracesEndpoint)
+		client, err := newOTLPClient(http.DefaultTransport)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("endpoint %q, traces endpoint %q: error = %v, wantErr %v", tt.endpoint, tt.tracesEndpoint, err, tt.wantErr)
+			continue
+		}
+		if err == nil && client.url != tt.want {
+			t.Errorf("endpoint %q, traces endpoint %q: got URL %s, want %s", tt.endpoint, tt.tracesEndpoint, client.url, tt.want)
+		}
+	}
+}
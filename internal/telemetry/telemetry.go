@@ -0,0 +1,68 @@
+// Package telemetry traces the requests served and the calls made by the demo, hiding the APM
+// provider behind a common interface so the rest of the code never depends on a given agent.
+package telemetry
+
+import (
+	"context"
+	"net/http"
+)
+
+// Provider traces the requests served and the outbound calls
+type Provider interface {
+	// WrapHandler wraps the handler in a transaction named after the pattern, available to the
+	// handler with FromContext
+	WrapHandler(pattern string, handler http.HandlerFunc) http.HandlerFunc
+	// StartTransaction starts a transaction for a request which is not served by a wrapped handler,
+	// continuing the trace context of the given headers. The returned context carries the
+	// transaction.
+	StartTransaction(ctx context.Context, name string, header http.Header) (context.Context, Transaction)
+	// RoundTripper wraps the transport so the requests sent through it are traced as external
+	// calls of the transaction of their context, and carry the trace context
+	RoundTripper(next http.RoundTripper) http.RoundTripper
+	// Shutdown flushes the pending telemetry and stops the provider, waiting at most until the
+	// context is done
+	Shutdown(ctx context.Context) error
+}
+
+// Transaction is the trace of a request being served
+type Transaction interface {
+	// AddAttribute adds a custom attribute, used to facet the transactions
+	AddAttribute(key string, value interface{})
+	// NoticeError records an error of the given class along with its attributes
+	NoticeError(err error, class string, attributes map[string]interface{})
+	// StartSegment starts timing a part of the transaction
+	StartSegment(name string) Segment
+	// InjectHeaders adds the trace context headers to the headers of an outbound call
+	InjectHeaders(header http.Header)
+	// NewGoroutine returns the transaction to use in another goroutine
+	NewGoroutine() Transaction
+	// BrowserTimingHeader returns the browser monitoring snippet injected in the served HTML pages,
+	// if any
+	BrowserTimingHeader() ([]byte, error)
+	// End ends the transaction
+	End()
+
+	// newContext returns a context carrying the transaction for the provider
+	newContext(ctx context.Context) context.Context
+}
+
+// Segment is a timed part of a transaction
+type Segment interface {
+	End()
+}
+
+type transactionKey struct{}
+
+// NewContext returns a context carrying the transaction
+func NewContext(ctx context.Context, txn Transaction) context.Context {
+	return context.WithValue(txn.newContext(ctx), transactionKey{}, txn)
+}
+
+// FromContext returns the transaction carried by the context, or a no-op transaction if there is
+// none
+func FromContext(ctx context.Context) Transaction {
+	if txn, ok := ctx.Value(transactionKey{}).(Transaction); ok {
+		return txn
+	}
+	return noopTransaction{}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var ipFilterDeniedTotal = newCounterVec("rollouts_demo_ip_filter_denied_total",
+	"Number of requests rejected with a 403 by the IP filter, by rule: deny or allow.",
+	"rule")
+
+// trustedProxies are the networks of the proxies whose X-Forwarded-For entries are trusted. When set,
+// clientIP returns the last address of the header not belonging to them, which clients can't spoof.
+var trustedProxies []*net.IPNet
+
+// containsIP returns whether one of the networks contains the address
+func containsIP(networks []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClientIP returns the address of the client from the X-Forwarded-For header of a request
+// sent by a trusted proxy: the last entry not belonging to the trusted proxies
+func forwardedClientIP(r *http.Request, remote string) string {
+	if !containsIP(trustedProxies, remote) {
+		return remote
+	}
+	entries := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		if !containsIP(trustedProxies, entry) {
+			return entry
+		}
+		remote = entry
+	}
+	return remote
+}
+
+// ipFilter rejects the requests of the clients outside of the allowed networks, or inside of the
+// denied ones, with a 403 before they reach the handlers, so network-policy-like behavior can be
+// tested at the application layer. Unlike clientIP, it only follows X-Forwarded-For through the
+// trusted proxies, as the first entry --trust-forwarded-for relies on is set by the client.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func (f ipFilter) wrap(next http.Handler) http.Handler {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := forwardedClientIP(r, remoteIP(r))
+		if containsIP(f.deny, ip) {
+			ipFilterDeniedTotal.inc("deny")
+			server.WriteError(w, r, http.StatusForbidden, "client address denied")
+			return
+		}
+		if len(f.allow) > 0 && !containsIP(f.allow, ip) {
+			ipFilterDeniedTotal.inc("allow")
+			server.WriteError(w, r, http.StatusForbidden, "client address not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network
+}
+
+func TestIPFilterSpoofedForwardedFor(t *testing.T) {
+	defer func(trust bool, proxies []*net.IPNet) {
+		trustForwardedFor, trustedProxies = trust, proxies
+	}(trustForwardedFor, trustedProxies)
+	trustForwardedFor = true
+
+	filter := ipFilter{
+		allow: []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")},
+		deny:  []*net.IPNet{mustParseCIDR(t, "192.0.2.66/32")},
+	}
+	handler := filter.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		proxies []*net.IPNet
+		remote  string
+		xff     string
+		want    int
+	}{
+		{name: "allowed address", remote: "10.1.2.3:1234", want: http.StatusOK},
+		{name: "spoofed allowed address", remote: "192.0.2.1:1234", xff: "10.1.2.3", want: http.StatusForbidden},
+		{name: "denied address hidden by a spoofed one", remote: "192.0.2.66:1234", xff: "10.1.2.3", want: http.StatusForbidden},
+		{
+			name:    "allowed address forwarded by a trusted proxy",
+			proxies: []*net.IPNet{mustParseCIDR(t, "192.0.2.0/24")},
+			remote:  "192.0.2.1:1234",
+			xff:     "10.1.2.3",
+			want:    http.StatusOK,
+		},
+		{
+			name:    "spoofed address prepended before a trusted proxy",
+			proxies: []*net.IPNet{mustParseCIDR(t, "192.0.2.0/24")},
+			remote:  "192.0.2.1:1234",
+			xff:     "10.1.2.3, 203.0.113.7",
+			want:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		trustedProxies = tt.proxies
+		r := httptest.NewRequest(http.MethodGet, "/color", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.SHA256
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var jwtRequestsTotal = newCounterVec("rollouts_demo_jwt_requests_total",
+	"Number of color requests checked for a bearer JWT, by result: ok, missing or invalid.",
+	"result")
+
+const (
+	// jwksRefreshInterval is the interval between the refreshes of the JWKS, picking up the rotated keys
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval bounds the refreshes triggered by the tokens signed with unknown keys
+	jwksMinRefreshInterval = time.Minute
+	// jwtLeeway is the clock skew tolerated when checking the expiry and not before claims
+	jwtLeeway = 30 * time.Second
+)
+
+// jwtConfig validates the bearer JWTs of the color API against the keys of a JWKS, and exposes their
+// subject and groups in the logs, the traces and the request events, so authn-aware routing and
+// per-tenant analysis can be demoed. It is disabled when no JWKS URL is configured.
+type jwtConfig struct {
+	jwksURL     string
+	issuer      string
+	audience    string
+	groupsClaim string
+
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// jwk is a key of a JWKS, RSA or EC
+type jwk struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// publicKey returns the public key of the JWK
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(v string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key %s", k.KeyID)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Type {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s of key %s", k.Curve, k.KeyID)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s of key %s", k.Type, k.KeyID)
+}
+
+// fetchKeys fetches the signing keys of the JWKS, skipping the unsupported ones
+func (c *jwtConfig) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := c.client.Get(c.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS returned %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key: %v", err)
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+// key returns the public key of the given id, refreshing the keys when they are stale or don't
+// include it, so the rotated keys are picked up
+func (c *jwtConfig) key(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[kid]
+	age := time.Since(c.fetchedAt)
+	if ok && age < jwksRefreshInterval {
+		return key, nil
+	}
+	if !ok && age < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	keys, err := c.fetchKeys()
+	if err != nil {
+		if ok {
+			log.Printf("Could not refresh the JWKS, keeping the current keys: %v", err)
+			return key, nil
+		}
+		return nil, fmt.Errorf("could not fetch the JWKS: %v", err)
+	}
+	c.keys, c.fetchedAt = keys, time.Now()
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// jwtClaims are the claims of a validated token
+type jwtClaims struct {
+	subject string
+	groups  []string
+}
+
+// validate checks the signature and the claims of the token and returns its subject and groups
+func (c *jwtConfig) validate(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return jwtClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed signature")
+	}
+	key, err := c.key(header.KeyID)
+	if err != nil {
+		return jwtClaims{}, err
+	}
+	if err := verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return jwtClaims{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return jwtClaims{}, err
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return jwtClaims{}, fmt.Errorf("expired token")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return jwtClaims{}, fmt.Errorf("token not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); c.issuer != "" && iss != c.issuer {
+		return jwtClaims{}, fmt.Errorf("token issued by %q", iss)
+	}
+	if c.audience != "" && !containsClaim(claims["aud"], c.audience) {
+		return jwtClaims{}, fmt.Errorf("token not issued for %q", c.audience)
+	}
+	sub, _ := claims["sub"].(string)
+	return jwtClaims{subject: sub, groups: stringsClaim(claims[c.groupsClaim])}, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	return nil
+}
+
+// verifySignature checks the signature of the signed content with the key, for the RSA and ECDSA
+// algorithms only, so unsigned and symmetrically signed tokens are rejected
+func verifySignature(algorithm string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(algorithm, "ES") || len(signature) != 2*size {
+			break
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("algorithm %q doesn't match the key", algorithm)
+}
+
+// containsClaim returns whether the claim, a string or an array of strings, contains the value
+func containsClaim(claim interface{}, value string) bool {
+	for _, v := range stringsClaim(claim) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stringsClaim returns the values of a string or array of strings claim
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// wrap rejects the requests without a valid bearer JWT with a 401, and records the subject and the
+// groups of the valid ones in the request info
+func (c *jwtConfig) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if c.jwksURL == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			jwtRequestsTotal.inc("missing")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			server.WriteError(w, r, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		claims, err := c.validate(token)
+		if err != nil {
+			jwtRequestsTotal.inc("invalid")
+			log.Printf("Rejecting bearer token: %v", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			server.WriteError(w, r, http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+		jwtRequestsTotal.inc("ok")
+		info := requestInfoFrom(w, r)
+		info.subject, info.groups = claims.subject, claims.groups
+		log.Printf("Authenticated %s (groups: %s)", claims.subject, strings.Join(claims.groups, ","))
+		next(w, r)
+	}
+}
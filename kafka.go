@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaRecord is the value of the record produced for every request
+type kafkaRecord struct {
+	requestEvent
+	Host string `json:"host"`
+}
+
+// kafkaProducer asynchronously writes a record per request to a Kafka topic, enabling
+// streaming-analytics based canary analysis
+type kafkaProducer struct {
+	writer *kafka.Writer
+	host   string
+}
+
+func newKafkaProducer(brokers []string, topic string) *kafkaProducer {
+	host, _ := os.Hostname()
+	log.Printf("Producing request records to Kafka topic %s", topic)
+	return &kafkaProducer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.LeastBytes{},
+			BatchTimeout: 100 * time.Millisecond,
+			Async:        true,
+			ErrorLogger:  kafka.LoggerFunc(log.Printf),
+		},
+		host: host,
+	}
+}
+
+func (p *kafkaProducer) record(event requestEvent) {
+	value, err := json.Marshal(kafkaRecord{requestEvent: event, Host: p.host})
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	// async writes never block, errors are reported through the error logger
+	_ = p.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(event.Color),
+		Value: value,
+	})
+}
+
+// close flushes the pending records
+func (p *kafkaProducer) close(ctx context.Context) error {
+	return p.writer.Close()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverTimeouts are the timeouts of the HTTP servers, so slow or idle clients can't hold their
+// connections forever. The write timeout is disabled by default, as it would cut the event streams,
+// the long polls, the large blobs and the long injected delays.
+var serverTimeouts = struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
+}{
+	read:       time.Minute,
+	readHeader: 10 * time.Second,
+	idle:       2 * time.Minute,
+}
+
+// newServer returns an HTTP server with the configured timeouts
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       serverTimeouts.read,
+		ReadHeaderTimeout: serverTimeouts.readHeader,
+		WriteTimeout:      serverTimeouts.write,
+		IdleTimeout:       serverTimeouts.idle,
+	}
+}
+
+// listenerConfig is a server listen address along with its optional TLS settings
+type listenerConfig struct {
+	addr     string
+	certFile string
+	keyFile  string
+	// maxConns bounds the connections accepted at once, unbounded when 0
+	maxConns int
+}
+
+func (l listenerConfig) String() string {
+	if !l.tls() {
+		return l.addr
+	}
+	return fmt.Sprintf("%s,cert=%s,key=%s", l.addr, l.certFile, l.keyFile)
+}
+
+func (l listenerConfig) tls() bool {
+	return l.certFile != "" && l.keyFile != ""
+}
+
+// url returns the base URL to reach the listener from the local host
+func (l listenerConfig) url() string {
+	scheme := "http"
+	if l.tls() {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(l.addr)
+	if err != nil {
+		return scheme + "://" + l.addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// serve starts accepting connections on the listener address using the given server, on the
+// listener handed off by the previous process if any. It exits the program if the server fails to
+// listen.
+func (l listenerConfig) serve(server *http.Server) {
+	ln, err := listen(l.addr)
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %v\n", l.addr, err)
+	}
+	if l.maxConns > 0 {
+		ln = limitListener(ln, l.maxConns)
+	}
+	if l.tls() {
+		log.Printf("Started TLS server on %s", l.addr)
+		err = server.ServeTLS(ln, l.certFile, l.keyFile)
+	} else {
+		log.Printf("Started server on %s", l.addr)
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Could not listen on %s: %v\n", l.addr, err)
+	}
+}
+
+// listenerConfigs is a repeatable flag of listen addresses. Each value has the format
+// <addr>[,cert=<path>,key=<path>], e.g. ":8443,cert=/tls/tls.crt,key=/tls/tls.key".
+type listenerConfigs []listenerConfig
+
+func (l *listenerConfigs) String() string {
+	if l == nil {
+		return ""
+	}
+	values := make([]string, 0, len(*l))
+	for _, listener := range *l {
+		values = append(values, listener.String())
+	}
+	return strings.Join(values, " ")
+}
+
+func (l *listenerConfigs) Set(value string) error {
+	parts := strings.Split(value, ",")
+	listener := listenerConfig{addr: strings.TrimSpace(parts[0])}
+	if listener.addr == "" {
+		return fmt.Errorf("missing listen address in %q", value)
+	}
+	for _, option := range parts[1:] {
+		split := strings.SplitN(option, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("invalid listener option %q", option)
+		}
+		switch key, val := strings.TrimSpace(split[0]), strings.TrimSpace(split[1]); key {
+		case "cert":
+			listener.certFile = val
+		case "key":
+			listener.keyFile = val
+		default:
+			return fmt.Errorf("unknown listener option %q", key)
+		}
+	}
+	if (listener.certFile == "") != (listener.keyFile == "") {
+		return fmt.Errorf("both cert and key must be set to serve TLS on %s", listener.addr)
+	}
+	*l = append(*l, listener)
+	return nil
+}
+
+// limitedListener accepts up to a maximum number of connections at once, the next ones waiting in
+// the backlog of the socket until one of the accepted connections is closed
+type limitedListener struct {
+	net.Listener
+	slots chan struct{}
+	// done is closed along with the listener, unblocking the Accept waiting for a slot
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func limitListener(ln net.Listener, max int) net.Listener {
+	return &limitedListener{Listener: ln, slots: make(chan struct{}, max), done: make(chan struct{})}
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+func (l *limitedListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// limitedConn releases its slot of the listener once closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// loadProfile returns the request rate to send at a given time since the start of the load
+type loadProfile func(elapsed time.Duration) float64
+
+// loadProfileNames are the supported load profiles
+var loadProfileNames = []string{"constant", "ramp", "spike", "sawtooth", "step"}
+
+// profileSteps is the number of steps the step profile climbs from the base to the peak rate in
+const profileSteps = 5
+
+// newLoadProfile returns the named profile varying the rate between the base and peak rates over the
+// given period:
+//   - constant sends the base rate
+//   - ramp increases linearly from the base to the peak rate over the period, then holds the peak
+//   - spike sends the peak rate during the last tenth of every period, and the base rate otherwise
+//   - sawtooth increases linearly from the base to the peak rate over every period, then drops back
+//   - step climbs from the base to the peak rate in equal steps over the period, then holds the peak
+func newLoadProfile(name string, base, peak float64, period time.Duration) (loadProfile, error) {
+	progress := func(elapsed time.Duration) float64 {
+		return math.Min(float64(elapsed)/float64(period), 1)
+	}
+	switch name {
+	case "constant":
+		return func(time.Duration) float64 { return base }, nil
+	case "ramp":
+		return func(elapsed time.Duration) float64 {
+			return base + (peak-base)*progress(elapsed)
+		}, nil
+	case "spike":
+		return func(elapsed time.Duration) float64 {
+			if elapsed%period >= period*9/10 {
+				return peak
+			}
+			return base
+		}, nil
+	case "sawtooth":
+		return func(elapsed time.Duration) float64 {
+			return base + (peak-base)*progress(elapsed%period)
+		}, nil
+	case "step":
+		return func(elapsed time.Duration) float64 {
+			step := math.Min(math.Floor(progress(elapsed)*profileSteps), profileSteps-1)
+			return base + (peak-base)*step/(profileSteps-1)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid load profile %q, expected one of %v", name, loadProfileNames)
+}
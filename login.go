@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var loginAttemptsTotal = newCounterVec("rollouts_demo_login_attempts_total",
+	"Number of attempts to log in to /login, by result: success, failure or throttled.",
+	"result")
+
+// loginAccount tracks the recent failed logins of an account
+type loginAccount struct {
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// loginService simulates a login endpoint failing at a configurable rate and throttling the accounts
+// after repeated failures, producing authentication failures distinct from the generic errors for
+// security analysis demos
+type loginService struct {
+	// failureRate is the percentage of the logins failing with a 401
+	failureRate int
+	// maxFailures is the number of failures within the lockout duration locking an account
+	maxFailures int
+	// lockout is the duration accounts stay locked, and the window their failures are counted in
+	lockout time.Duration
+
+	mu        sync.Mutex
+	accounts  map[string]*loginAccount
+	lastSweep time.Time
+}
+
+var login = &loginService{accounts: make(map[string]*loginAccount), lastSweep: time.Now()}
+
+// loginResponse is the body of the successful login responses
+type loginResponse struct {
+	XMLName  xml.Name `json:"-" xml:"login"`
+	Username string   `json:"username" xml:"username"`
+	Status   string   `json:"status" xml:"status"`
+}
+
+// locked returns how long the account stays locked, discarding the expired accounts once in a while
+func (s *loginService) locked(username string, now time.Time) time.Duration {
+	if now.Sub(s.lastSweep) > s.lockout {
+		for key, account := range s.accounts {
+			if now.Sub(account.firstFailed) > s.lockout && now.After(account.lockedUntil) {
+				delete(s.accounts, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	if account, ok := s.accounts[username]; ok && now.Before(account.lockedUntil) {
+		return account.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// failed records a failed login, locking the account once it failed too many times
+func (s *loginService) failed(username string, now time.Time) {
+	account, ok := s.accounts[username]
+	if !ok || now.Sub(account.firstFailed) > s.lockout {
+		account = &loginAccount{firstFailed: now}
+		s.accounts[username] = account
+	}
+	account.failures++
+	if s.maxFailures > 0 && account.failures >= s.maxFailures {
+		account.lockedUntil = now.Add(s.lockout)
+	}
+}
+
+// serveLogin accepts the credentials of a JSON or form body. The logins fail at the configured rate
+// or when the password is missing, and the accounts failing repeatedly get 429s until the lockout
+// expires, even with valid credentials.
+func (s *loginService) serveLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if !decodeBody(w, r, "login", &credentials, http.StatusBadRequest) {
+			return
+		}
+	} else {
+		body, ok := readBody(w, r, "login")
+		if !ok {
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			server.WriteError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		credentials.Username, credentials.Password = form.Get("username"), form.Get("password")
+	}
+	if credentials.Username == "" {
+		server.WriteError(w, r, http.StatusBadRequest, "missing username")
+		return
+	}
+
+	wait, fail := s.attempt(credentials.Username, credentials.Password)
+	switch {
+	case wait > 0:
+		loginAttemptsTotal.inc("throttled")
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+		server.WriteError(w, r, http.StatusTooManyRequests, "too many failed logins")
+	case fail:
+		loginAttemptsTotal.inc("failure")
+		w.Header().Set("WWW-Authenticate", `Basic realm="rollouts-demo"`)
+		server.WriteError(w, r, http.StatusUnauthorized, "invalid credentials")
+	default:
+		loginAttemptsTotal.inc("success")
+		server.WriteResponse(w, r, http.StatusOK, loginResponse{Username: credentials.Username, Status: "logged in"})
+	}
+}
+
+// attempt returns how long the account stays locked if it is, or whether the login fails
+func (s *loginService) attempt(username, password string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if wait := s.locked(username, now); wait > 0 {
+		return wait, false
+	}
+	if password == "" || randIntn(100) < s.failureRate {
+		s.failed(username, now)
+		return 0, true
+	}
+	delete(s.accounts, username)
+	return 0, false
+}
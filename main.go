@@ -2,23 +2,24 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"flag"
 	"fmt"
-	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/faults"
+	"github.com/argoproj/rollouts-demo/internal/server"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+	"golang.org/x/time/rate"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"runtime"
-	"strconv"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
-	"unicode/utf8"
 )
 
 const (
@@ -32,8 +33,9 @@ const (
 )
 
 var (
-	color  = os.Getenv("COLOR")
-	colors = []string{
+	// allowColorOverride allows forcing the response color with the color query parameter
+	allowColorOverride = true
+	colors             = []string{
 		"red",
 		"orange",
 		"yellow",
@@ -41,273 +43,783 @@ var (
 		"blue",
 		"purple",
 	}
-	envErrorRate = os.Getenv("ERROR_RATE")
-	envLatency   = os.Getenv("LATENCY")
 )
 
 func main() {
-	var app *newrelic.Application
+	if err := resolveSecretFiles(); err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		runClient(os.Args[2:])
+		return
+	}
+
+	var (
+		listeners        listenerConfigs
+		apm              apmConfig
+		errorGroupList   string
+		snippetFile      string
+		staticDir        string
+		uiControls       bool
+		uiAuthMode       string
+		apiKeysFile      string
+		jwtAuth          jwtConfig
+		secHeaders       securityHeaders
+		allowCIDRs       string
+		denyCIDRs        string
+		proxyCIDRs       string
+		tlsOptions       tlsSettings
+		adminAddr        string
+		proxyUpstream    string
+		udpAddr          string
+		mqttBroker       string
+		mqttTopic        string
+		mqttClientID     string
+		kafkaBrokers     string
+		kafkaTopic       string
+		natsURL          string
+		natsSubject      string
+		natsQueue        string
+		faultWebhookURL  string
+		maxBlob          string
+		maxBody          string
+		noKeepAlives     bool
+		maxHeader        string
+		maxConnections   int
+		ballastSize      string
+		gcPercent        string
+		stickySessions   bool
+		rateLimitValue   string
+		rateLimitBurst   int
+		clientRateLimit  string
+		clientBurst      int
+		maxConcurrency   int
+		maxQueueDepth    int
+		maxQueueWait     time.Duration
+		terminationDelay secondsValue
+		crashAfterN      int64
+		numCPUBurn       string
+		corsOrigins      string
+		corsMethods      string
+		corsHeaders      string
+		flipColorList    string
+		variants         string
+		analysisHeader   string
+		retryStatuses    string
+		failPolicyValue  string
+		topologyFile     string
+		topologyBaseURL  string
+		selfTrafficRPS   float64
+		selfTrafficURL   string
+		warmupRequests   int
+		warmupURL        string
+		warmupTimeout    time.Duration
+		grpcAddr         string
+	)
+	flag.StringVar(&apm.mode, "apm", "newrelic", "APM provider tracing the requests: newrelic, otel or off, e.g. in clusters without APM (New Relic is also disabled by NEW_RELIC_ENABLED=false)")
+	flag.StringVar(&apm.proxyURL, "apm-proxy-url", "", "URL of the proxy the APM agent or exporter connects through (defaults to the HTTPS_PROXY environment variable)")
+	flag.StringVar(&apm.caFile, "apm-ca-file", "", "PEM file of the CA certificates verifying the APM endpoint, e.g. of a TLS intercepting proxy (defaults to the system ones)")
+	flag.BoolVar(&apm.insecureSkipVerify, "apm-insecure-skip-verify", false, "skip the verification of the APM endpoint certificate")
+	flag.StringVar(&apm.appName, "apm-app-name", defaultAPMAppName, "New Relic application name, where {namespace}, {pod}, {role} and {color} are replaced with the pod metadata (also in NEW_RELIC_LABELS)")
+	flag.StringVar(&errorGroupList, "error-grouping-attributes", strings.Join(errorGrouping, ","), fmt.Sprintf("comma separated list of the attributes of the recorded errors combined in their errorGroup attribute, among %v", errorGroupingNames))
+	flag.StringVar(&staticDir, "static-dir", "", "directory of the UI files served instead of the ones embedded in the binary, e.g. while developing the UI")
+	flag.StringVar(&uiAuthMode, "ui-auth", "none", "authentication of the UI and its controls: none, basic (UI_AUTH_USERNAME and UI_AUTH_PASSWORD) or oidc (OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL and UI_AUTH_SECRET)")
+	flag.BoolVar(&uiControls, "ui-controls", false, "serve the /admin/settings API on the user listeners too, enabling the fault injection controls of the dashboard")
+	flag.StringVar(&apiKeysFile, "api-keys-file", "", "file of <name>:<key> lines, requiring one of the keys in the X-API-Key header of the /color requests (disabled when empty)")
+	flag.StringVar(&jwtAuth.jwksURL, "jwt-jwks-url", "", "URL of the JWKS validating the bearer JWT required on /color, exposing its sub and groups claims in the logs and traces (disabled when empty)")
+	flag.StringVar(&jwtAuth.issuer, "jwt-issuer", "", "required iss claim of the bearer JWTs (not checked when empty)")
+	flag.StringVar(&jwtAuth.audience, "jwt-audience", "", "required aud claim of the bearer JWTs (not checked when empty)")
+	flag.StringVar(&jwtAuth.groupsClaim, "jwt-groups-claim", "groups", "claim of the bearer JWTs listing the groups of the subject")
+	flag.DurationVar(&secHeaders.hstsMaxAge, "hsts-max-age", 0, "max-age of the Strict-Transport-Security header of the UI responses, e.g. 8760h (disabled when 0)")
+	flag.StringVar(&secHeaders.contentSecurityPolicy, "content-security-policy", "", "Content-Security-Policy header of the UI responses, e.g. \"default-src 'self'\" (disabled when empty)")
+	flag.StringVar(&secHeaders.frameOptions, "frame-options", "", "X-Frame-Options header of the UI responses: DENY or SAMEORIGIN (disabled when empty)")
+	flag.StringVar(&secHeaders.referrerPolicy, "referrer-policy", "", "Referrer-Policy header of the UI responses, e.g. no-referrer (disabled when empty)")
+	flag.StringVar(&snippetFile, "browser-snippet-file", "", "file of a browser monitoring snippet (e.g. OpenTelemetry web) injected in the UI pages, after the New Relic one")
+	flag.Var(&listeners, "listen-addr", "server listen address with optional TLS settings, e.g. ':8443,cert=tls.crt,key=tls.key' (can be repeated, default ':8080')")
+	flag.StringVar(&tlsOptions.minVersion, "tls-min-version", "1.2", "minimum TLS version of the TLS listeners: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&tlsOptions.cipherSuites, "tls-cipher-suites", "", "comma separated cipher suites of the TLS listeners up to TLS 1.2, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Go defaults when empty)")
+	flag.StringVar(&tlsOptions.curves, "tls-curve-preferences", "", "comma separated curves of the TLS listeners in preference order, among X25519, P256, P384 and P521 (Go defaults when empty)")
+	flag.StringVar(&adminAddr, "admin-addr", ":8081", "admin listen address serving health, metrics, pprof and admin APIs (empty serves them on the user listeners)")
+	flag.StringVar(&proxyUpstream, "proxy-upstream", "", "reverse proxy /color to this upstream URL, applying the configured faults on the way through")
+	flag.StringVar(&udpAddr, "udp-addr", "", "UDP listen address replying to any datagram with the current color (disabled when empty)")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "gRPC listen address serving the color service (disabled when empty)")
+	flag.StringVar(&mqttBroker, "mqtt-broker", "", "MQTT broker URL (e.g. tcp://mosquitto:1883) to publish color and health state changes to (disabled when empty)")
+	flag.StringVar(&mqttTopic, "mqtt-topic", "rollouts-demo/color", "MQTT topic to publish color and health state changes to")
+	flag.StringVar(&mqttClientID, "mqtt-client-id", "", "MQTT client id (defaults to rollouts-demo-<hostname>)")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "", "comma separated list of Kafka brokers to produce a record per request to (disabled when empty)")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "rollouts-demo-requests", "Kafka topic to produce request records to")
+	flag.StringVar(&natsURL, "nats-url", "", "NATS server URL (e.g. nats://nats:4222) to serve color requests from (disabled when empty)")
+	flag.StringVar(&natsSubject, "nats-subject", "rollouts-demo.color", "NATS subject to reply to with the current color")
+	flag.StringVar(&natsQueue, "nats-queue", "rollouts-demo", "NATS queue group, load balancing the requests between replicas")
+	flag.StringVar(&faultWebhookURL, "fault-webhook-url", "", "URL to post a JSON event to whenever an error or a delay is injected (disabled when empty)")
+	flag.IntVar(&login.failureRate, "login-failure-rate", 0, "percentage of the /login attempts failing with a 401")
+	flag.IntVar(&login.maxFailures, "login-max-failures", 5, "number of failed /login attempts of an account locking it with 429s (disabled when 0)")
+	flag.DurationVar(&login.lockout, "login-lockout", time.Minute, "duration accounts stay locked after too many failed /login attempts, and the window the failures are counted in")
+	flag.StringVar(&maxBlob, "max-blob-size", "1GiB", "maximum size of the blobs generated by /blob")
+	flag.StringVar(&maxBody, "max-body-size", "1MiB", "maximum size of the /color request bodies, larger ones get a 413")
+	flag.BoolVar(&stickySessions, "sticky-sessions", false, "keep returning the first color served to a session, tracked with a session cookie")
+	flag.StringVar(&sessionCookie, "session-cookie", "rollouts-demo-color", "name of the session cookie used by sticky sessions")
+	flag.BoolVar(&allowColorOverride, "allow-color-override", true, "allow forcing the color with the color query parameter, e.g. /color?color=purple")
+	flag.StringVar(&rateLimitValue, "rate-limit", "", "maximum rate of user requests (e.g. 100rps, 100/s or 6000/m), exceeding requests get a 429 (disabled when empty)")
+	flag.IntVar(&rateLimitBurst, "burst", 20, "maximum burst of user requests allowed above the rate limit")
+	flag.StringVar(&clientRateLimit, "client-rate-limit", "", "maximum rate of user requests per client IP (e.g. 10rps), exceeding requests get a 429 (disabled when empty)")
+	flag.IntVar(&clientBurst, "client-burst", 5, "maximum burst of user requests per client IP allowed above the client rate limit")
+	flag.BoolVar(&trustForwardedFor, "trust-forwarded-for", false, "identify clients by the X-Forwarded-For header, only safe behind a proxy setting it")
+	flag.StringVar(&proxyCIDRs, "trusted-proxies", "", "comma separated CIDRs of the proxies whose X-Forwarded-For entries are trusted, identifying clients by the last untrusted entry")
+	flag.StringVar(&allowCIDRs, "allow-cidrs", "", "comma separated CIDRs of the only clients allowed, the others getting a 403 (disabled when empty)")
+	flag.StringVar(&denyCIDRs, "deny-cidrs", "", "comma separated CIDRs of the clients denied with a 403, taking precedence over --allow-cidrs")
+	flag.IntVar(&maxConcurrency, "max-concurrency", 0, "maximum number of user requests served concurrently, requests beyond it are shed with a 503 (disabled when 0)")
+	flag.IntVar(&maxQueueDepth, "max-queue-depth", 0, "maximum number of requests waiting for a slot when the maximum concurrency is reached")
+	flag.DurationVar(&maxQueueWait, "max-queue-wait", 5*time.Second, "maximum time requests wait for a slot before being shed")
+	flag.StringVar(&flipColorList, "flip-colors", "blue,green", "the two colors switched by the /admin/flip action")
+	flag.Int64Var(&crashAfterN, "crash-after-requests", 0, "exit with code 1 after serving this number of requests, to test restart policies and rollout aborts (disabled when 0)")
+	flag.BoolVar(&allowAbort, "allow-abort", false, "allow the /admin/abort action to exit the process immediately with code 137, for crash simulations")
+	flag.StringVar(&experiment.name, "experiment-name", experiment.name, "name of the experiment served by /assign, salting the user hashes")
+	flag.StringVar(&variants, "experiment-variants", "blue:50,green:50", "comma separated list of variant:weight pairs the users are assigned to by /assign")
+	flag.StringVar(&analysis.userAgent, "analysis-user-agent", "argo-rollouts", "identify the requests whose User-Agent contains this value as analysis traffic (disabled when empty)")
+	flag.StringVar(&analysisHeader, "analysis-header", "", "identify the requests with this name:value header as analysis traffic, e.g. X-Analysis:true")
+	flag.DurationVar(&upstreamTimeouts.request, "upstream-timeout", upstreamTimeouts.request, "default timeout of the upstream requests")
+	flag.DurationVar(&upstreamTimeouts.connect, "upstream-connect-timeout", upstreamTimeouts.connect, "default timeout of the connections to the upstreams")
+	flag.DurationVar(&upstreamHedgeDelay, "upstream-hedge-delay", 0, "send a second upstream request when the first one has not been answered after this delay, using the first response (disabled when 0)")
+	flag.StringVar(&failPolicyValue, "upstream-fail-policy", string(failClosed), "how upstream failures are handled: closed returns a 5xx, open returns a fallback color")
+	flag.StringVar(&upstreamFallbackColor, "upstream-fallback-color", "", "color used in place of a failed upstream one when failing open (defaults to the local color)")
+	flag.IntVar(&upstreamMaxConcurrency, "upstream-max-concurrency", 0, "default maximum number of concurrent requests and connections per upstream, requests beyond it are rejected (unbounded when 0)")
+	flag.IntVar(&upstreamTransport.maxIdleConnsPerHost, "upstream-max-idle-conns-per-host", upstreamTransport.maxIdleConnsPerHost, "maximum number of idle connections kept in the pool per upstream host")
+	flag.DurationVar(&upstreamTransport.idleConnTimeout, "upstream-idle-conn-timeout", upstreamTransport.idleConnTimeout, "duration after which the idle upstream connections are closed (never when 0)")
+	flag.StringVar(&upstreamTransport.caFile, "upstream-ca-file", "", "PEM file of the CA certificates verifying the upstream servers (defaults to the system ones)")
+	flag.StringVar(&upstreamTransport.certFile, "upstream-cert-file", "", "PEM file of the client certificate presented to the upstreams")
+	flag.StringVar(&upstreamTransport.keyFile, "upstream-key-file", "", "PEM file of the key of the client certificate presented to the upstreams")
+	flag.BoolVar(&upstreamTransport.insecureSkipVerify, "upstream-insecure-skip-verify", false, "skip the verification of the upstream server certificates")
+	flag.IntVar(&upstreamRetry.retries, "upstream-retries", 0, "maximum number of retries of the failed upstream requests")
+	flag.DurationVar(&upstreamRetry.backoff, "upstream-retry-backoff", upstreamRetry.backoff, "delay before the first upstream retry, doubled on every retry")
+	flag.StringVar(&retryStatuses, "upstream-retry-statuses", "502,503,504", "comma separated list of retryable upstream status codes, transport errors are always retried")
+	flag.StringVar(&topologyFile, "topology", "", "topology YAML file describing the virtual services simulated by the process (disabled when empty)")
+	flag.StringVar(&topologyBaseURL, "topology-base-url", "", "base URL the virtual services call their dependencies through (defaults to the first listener)")
+	flag.Float64Var(&selfTrafficRPS, "self-traffic-rps", 0, "number of synthetic requests per second sent to the self traffic URL, so there is always traffic to analyze (disabled when 0)")
+	flag.StringVar(&selfTrafficURL, "self-traffic-url", "", "URL the synthetic requests are sent to, e.g. the Service of the pod (defaults to /color on the first listener)")
+	flag.IntVar(&warmupRequests, "warmup-requests", 0, "number of warm-up requests sent to the warm-up URL before /readyz reports ready (disabled when 0)")
+	flag.StringVar(&warmupURL, "warmup-url", "", "URL the warm-up requests are sent to (defaults to /color on the first listener)")
+	flag.DurationVar(&warmupTimeout, "warmup-timeout", 30*time.Second, "maximum duration of the warm-up, after which /readyz reports ready anyway")
+	terminationDelay = secondsValue(shutdown.terminationDelay)
+	flag.Var(&terminationDelay, "termination-delay", "termination delay, in seconds or as a duration, e.g. 1m30s (overridden by the TERMINATION_DELAY environment variable)")
+	flag.DurationVar(&serverTimeouts.read, "read-timeout", serverTimeouts.read, "maximum duration of the reading of a request, body included (unbounded when 0)")
+	flag.DurationVar(&serverTimeouts.readHeader, "read-header-timeout", serverTimeouts.readHeader, "maximum duration of the reading of the request headers (defaults to the read timeout when 0)")
+	flag.DurationVar(&serverTimeouts.write, "write-timeout", serverTimeouts.write, "maximum duration of a response from the end of the request headers, cutting the streams and the long delays too (unbounded when 0)")
+	flag.DurationVar(&serverTimeouts.idle, "idle-timeout", serverTimeouts.idle, "duration after which the idle keep-alive connections are closed (defaults to the read timeout when 0)")
+	flag.BoolVar(&noKeepAlives, "disable-keep-alives", false, "close the user connections after every request, so every request opens a new connection")
+	flag.StringVar(&maxHeader, "max-header-bytes", "1MiB", "maximum size of the request line and headers of the user requests, larger ones get a 431")
+	flag.IntVar(&maxConnections, "max-connections", 0, "maximum number of connections accepted at once per user listener, the next ones waiting in the backlog (unbounded when 0)")
+	flag.DurationVar(&shutdown.timeout, "shutdown-timeout", shutdown.timeout, "maximum duration of the graceful shutdown after the termination delay (overridden by the SHUTDOWN_TIMEOUT environment variable)")
+	flag.DurationVar(&shutdown.hooksTimeout, "shutdown-hooks-timeout", shutdown.hooksTimeout, "maximum duration of each cleanup hook run once the servers are stopped, e.g. the telemetry flush")
+	flag.StringVar(&ballastSize, "ballast", "0", "size of a GC ballast allocated at startup, e.g. 1GiB, making the GC less frequent with small heaps (disabled when 0)")
+	flag.StringVar(&gcPercent, "gogc", "", "garbage collection target percentage, or off, overriding the GOGC environment variable")
+	flag.StringVar(&numCPUBurn, "cpu-burn", "", "burn specified number of cpus (number or 'all'), changed at runtime with /admin/cpu-burn")
+	flag.StringVar(&corsOrigins, "cors-allowed-origins", "", "comma separated list of origins allowed to call the API ('*' allows any origin, empty disables CORS)")
+	flag.StringVar(&corsMethods, "cors-allowed-methods", "GET,POST,OPTIONS", "comma separated list of methods allowed in CORS requests")
+	flag.StringVar(&corsHeaders, "cors-allowed-headers", "Content-Type", "comma separated list of headers allowed in CORS requests")
+	flag.Parse()
+	setMaxProcs()
+
 	var err error
+	shutdown.terminationDelay = time.Duration(terminationDelay)
+	if err = shutdown.resolve(); err != nil {
+		log.Fatal(err)
+	}
+	if errorGrouping, err = parseErrorGrouping(errorGroupList); err != nil {
+		log.Fatal(err)
+	}
 
-	useEnvConfig := os.Getenv("NEW_RELIC_USE_ENV_CONFIG")
-	if useEnvConfig == "true" {
-		app, err = newrelic.NewApplication(newrelic.ConfigFromEnvironment())
-	} else {
-		app, err = newrelic.NewApplication(
-			newrelic.ConfigDebugLogger(os.Stdout),
-			newrelic.ConfigEnabled(true),
-			newrelic.ConfigDistributedTracerEnabled(true),
-			newrelic.ConfigLicense(os.Getenv("NEW_RELIC_LICENSE_KEY")),
-			newrelic.ConfigAppName("connect-service-cell-app"),
-			func(cfg *newrelic.Config) {
-				cfg.ErrorCollector.Enabled = true
-				cfg.ErrorCollector.RecordPanics = true
-				cfg.ErrorCollector.CaptureEvents = true
-				cfg.ErrorCollector.Attributes.Enabled = true
-				cfg.TransactionTracer.Enabled = true
-				cfg.TransactionTracer.Attributes.Enabled = true
-				cfg.CustomInsightsEvents.Enabled = true
-				cfg.Utilization.DetectKubernetes = true
-				cfg.Transport = &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				}
+	if len(listeners) == 0 {
+		listeners = listenerConfigs{{addr: ":8080"}}
+	}
 
-				if envLabels := os.Getenv("NEW_RELIC_LABELS"); envLabels != "" {
-					if labels := getLabels(envLabels); len(labels) > 0 {
-						cfg.Labels = labels
-					} else {
-						cfg.Error = fmt.Errorf("invalid NEW_RELIC_LABELS value: %s", envLabels)
-					}
-				}
-			})
+	cors := corsConfig{
+		allowedOrigins: config.SplitList(corsOrigins),
+		allowedMethods: config.SplitList(corsMethods),
+		allowedHeaders: config.SplitList(corsHeaders),
 	}
 
-	if nil != err {
+	flip := config.SplitList(flipColorList)
+	if len(flip) != 2 || !validColorName(flip[0]) || !validColorName(flip[1]) {
+		log.Fatalf("Invalid flip colors %s: expected two comma separated colors", flipColorList)
+	}
+	flipColors = [2]string{flip[0], flip[1]}
+
+	if upstreamFailPolicy, err = parseFailPolicy(failPolicyValue); err != nil {
+		log.Fatal(err)
+	}
+	if !validColorName(upstreamFallbackColor) {
+		log.Fatalf("Invalid upstream fallback color %s", upstreamFallbackColor)
+	}
+	if upstreamRetry.statuses, err = parseStatuses(retryStatuses); err != nil {
+		log.Fatalf("Invalid upstream retry statuses %s: %v", retryStatuses, err)
+	}
+	if upstreamRetry.retries < 0 || upstreamRetry.backoff <= 0 {
+		log.Fatal("Upstream retries must not be negative and the retry backoff must be positive")
+	}
+
+	if experiment.variants, err = config.ParseWeights(variants); err != nil {
+		log.Fatalf("Invalid experiment variants %s: %v", variants, err)
+	}
+	if len(experiment.variants) == 0 {
+		log.Fatal("At least one experiment variant is required")
+	}
+
+	if !stickySessions {
+		sessionCookie = ""
+	}
+
+	maxBlobSize, err := config.ParseSize(maxBlob)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if maxBodySize, err = config.ParseSize(maxBody); err != nil {
+		log.Fatal(err)
+	}
+	maxHeaderBytes, err := config.ParseSize(maxHeader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ballastBytes, err := config.ParseSize(ballastSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	allocateBallast(ballastBytes)
+	if err = setGCPercent(gcPercent); err != nil {
+		log.Fatal(err)
+	}
+	var cpuBurnCount int
+	if numCPUBurn != "" {
+		if cpuBurnCount, err = parseCPUBurn(numCPUBurn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	initialSettings, err := settingsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if zone, err = zoneFromEnv(identity.zone); err != nil {
+		log.Fatal(err)
+	}
+	if initialSettings.Color == "" && zone.color != "" {
+		log.Printf("Using the %s color of zone %s", zone.color, identity.zone)
+		initialSettings.Color = zone.color
+	}
+	if err := state.set(initialSettings); err != nil {
+		log.Fatal(err)
+	}
+	if apmProvider, err = newAPMProvider(apm, initialSettings.Color); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	// registered first to flush the traces of everything stopped before it
+	onShutdown("telemetry", apmProvider.Shutdown)
+	if canary, err = canaryFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if analysis.header, err = parseAnalysisHeader(analysisHeader); err != nil {
+		log.Fatal(err)
+	}
+	if err := analysis.faultsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if clientFaults, err = clientFaultsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if colorAnnotations, err = colorAnnotationsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := upstreamTransport.loadTLS(); err != nil {
+		log.Fatal(err)
+	}
+	if upstreamChain, err = upstreamChainFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	for i, u := range upstreamChain {
+		log.Printf("Chaining hop %d of /color to %s", i, u.url)
+	}
+	if fanoutUpstreams, err = fanoutUpstreamsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if ramp, err = rampFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if ramp != nil {
+		log.Printf("Ramping from %s to %s over %v", ramp.from, ramp.to, ramp.duration)
+	}
 
-	var (
-		listenAddr       string
-		terminationDelay int
-		numCPUBurn       string
-	)
-	flag.StringVar(&listenAddr, "listen-addr", ":8080", "server listen address")
-	flag.IntVar(&terminationDelay, "termination-delay", defaultTerminationDelay, "termination delay in seconds")
-	flag.StringVar(&numCPUBurn, "cpu-burn", "", "burn specified number of cpus (number or 'all')")
-	flag.Parse()
-
-	rand.Seed(time.Now().UnixNano())
+	if faultWebhookURL != "" {
+		webhook = newFaultWebhook(faultWebhookURL)
+	}
 
 	router := http.NewServeMux()
-	router.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./"))))
-	router.HandleFunc(newrelic.WrapHandleFunc(app, "/color", getColor))
+	var browserSnippet []byte
+	if snippetFile != "" {
+		if browserSnippet, err = ioutil.ReadFile(snippetFile); err != nil {
+			log.Fatalf("Could not read the browser snippet: %v", err)
+		}
+	}
+	uiDir := http.FS(uiFiles)
+	if staticDir != "" {
+		log.Printf("Serving the UI from %s", staticDir)
+		uiDir = http.Dir(staticDir)
+	}
+	auth, err := uiAuthFromEnv(uiAuthMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth.oidc != nil {
+		log.Printf("Authenticating the UI users with %s", auth.oidc.issuer)
+		router.HandleFunc(auth.oidc.callbackPath, auth.oidc.callback)
+	}
+	router.HandleFunc("/", secHeaders.wrap(auth.wrap(serveUI(uiDir, browserSnippet))))
+	colorEndpoint := newColorServer()
+	colorFunc := colorEndpoint.ServeHTTP
+	if proxyUpstream != "" {
+		upstream, err := url.Parse(proxyUpstream)
+		if err != nil {
+			log.Fatalf("Invalid proxy upstream %s: %v", proxyUpstream, err)
+		}
+		log.Printf("Proxying /color to %s", upstream)
+		colorFunc = proxyColor(newColorProxy(upstream))
+	}
+	var keys apiKeys
+	if apiKeysFile != "" {
+		if keys, err = loadAPIKeys(apiKeysFile); err != nil {
+			log.Fatalf("Could not load the API keys: %v", err)
+		}
+		log.Printf("Requiring one of %d API keys on /color", len(keys))
+	}
+	if jwtAuth.jwksURL != "" {
+		log.Printf("Requiring a bearer JWT signed by a key of %s on /color", jwtAuth.jwksURL)
+		jwtAuth.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	router.HandleFunc("/color", instrument("color", cors.wrap(keys.wrap(withIdentityHeaders(traced("/color", jwtAuth.wrap(colorFunc)))))))
+	router.HandleFunc("/color/wait", instrument("color_wait", cors.wrap(keys.wrap(withIdentityHeaders(traced("/color/wait", jwtAuth.wrap(waitColor(colorEndpoint))))))))
+	router.HandleFunc("/blob", instrument("blob", withIdentityHeaders(traced("/blob", server.NewBlob(maxBlobSize).ServeHTTP))))
+	router.HandleFunc("/events", cors.wrap(auth.wrap(withIdentityHeaders(streamEvents))))
+	router.HandleFunc("/ws", auth.wrap(withIdentityHeaders(streamWebSocket)))
+	onRequest(events.publish)
+	router.HandleFunc("/stats", instrument("stats", cors.wrap(auth.wrap(withIdentityHeaders(traced("/stats", stats.ServeHTTP))))))
+	onRequest(recordStats)
+	router.HandleFunc("/colors", instrument("colors", cors.wrap(withIdentityHeaders(traced("/colors", getColors)))))
+	if topologyFile != "" {
+		if topologyBaseURL == "" {
+			topologyBaseURL = listeners[0].url()
+		}
+		if topology, err = loadTopology(topologyFile, topologyBaseURL); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Simulating %d virtual services", len(topology.Services))
+		router.HandleFunc("/services/", instrument("service", cors.wrap(withIdentityHeaders(traced("/services/", serveVirtualService)))))
+	}
+	router.HandleFunc("/topology", instrument("topology", cors.wrap(withIdentityHeaders(traced("/topology", getTopology)))))
+	router.HandleFunc("/assign", instrument("assign", cors.wrap(withIdentityHeaders(traced("/assign", assign)))))
+	router.HandleFunc("/echo", instrument("echo", cors.wrap(withIdentityHeaders(traced("/echo", echo)))))
+	router.HandleFunc("/login", instrument("login", cors.wrap(withIdentityHeaders(traced("/login", login.serveLogin)))))
 
-	server := &http.Server{
-		Addr:    listenAddr,
-		Handler: router,
+	// only the application routes count toward the crash, not the probes and metrics served on the
+	// same port when there is no admin address
+	app := http.Handler(router)
+	if crashAfterN > 0 {
+		log.Printf("Will crash after serving %d requests", crashAfterN)
+		app = crashAfter(crashAfterN, app)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", app)
+	var handler http.Handler = mux
+	if maxConcurrency > 0 {
+		log.Printf("Limiting concurrency to %d requests (queue depth %d)", maxConcurrency, maxQueueDepth)
+		handler = newConcurrencyLimiter(maxConcurrency, maxQueueDepth, maxQueueWait).wrap(handler)
+	}
+	if rateLimitValue != "" {
+		limit, err := parseRate(rateLimitValue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Limiting requests to %v/s (burst %d)", float64(limit), rateLimitBurst)
+		handler = rateLimit(rate.NewLimiter(limit, rateLimitBurst), handler)
+	}
+	if clientRateLimit != "" {
+		limit, err := parseRate(clientRateLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Limiting requests to %v/s per client (burst %d)", float64(limit), clientBurst)
+		handler = newClientRateLimiter(limit, clientBurst).wrap(handler)
+	}
+	if trustedProxies, err = config.ParseCIDRs(proxyCIDRs); err != nil {
+		log.Fatal(err)
+	}
+	var filter ipFilter
+	if filter.allow, err = config.ParseCIDRs(allowCIDRs); err != nil {
+		log.Fatal(err)
+	}
+	if filter.deny, err = config.ParseCIDRs(denyCIDRs); err != nil {
+		log.Fatal(err)
+	}
+	handler = filter.wrap(handler)
+	handler = trackInFlight(lameDuck(handler))
+
+	tlsConfig, err := tlsOptions.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	servers := make([]*http.Server, 0, len(listeners)+1)
+	for i, listener := range listeners {
+		listeners[i].maxConns = maxConnections
+		server := newServer(listener.addr, handler)
+		server.MaxHeaderBytes = int(maxHeaderBytes)
+		server.SetKeepAlivesEnabled(!noKeepAlives)
+		if listener.tls() {
+			server.TLSConfig = tlsConfig.Clone()
+		}
+		servers = append(servers, server)
+	}
+
+	admin, err := adminAuthFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		adminRouter := http.NewServeMux()
+		registerAdminHandlers(adminRouter, admin)
+		listeners = append(listeners, listenerConfig{addr: adminAddr})
+		servers = append(servers, newServer(adminAddr, adminRouter))
+		if uiControls {
+			// exposed to the users on purpose, so presenters can break the canary from the browser
+			router.HandleFunc("/admin/settings", auth.wrap(adminSettings))
+		}
+	} else {
+		registerAdminHandlers(mux, admin)
+	}
+
+	if udpAddr != "" {
+		udpConn, err := serveUDP(udpAddr)
+		if err != nil {
+			log.Fatalf("Could not listen on udp %s: %v\n", udpAddr, err)
+		}
+		onShutdown("UDP responder", func(context.Context) error {
+			return udpConn.Close()
+		})
+	}
+
+	if grpcAddr != "" {
+		grpcServer, err := serveGRPC(grpcAddr)
+		if err != nil {
+			log.Fatalf("Could not listen on %s: %v\n", grpcAddr, err)
+		}
+		onShutdown("gRPC server", func(context.Context) error {
+			grpcServer.GracefulStop()
+			return nil
+		})
+	}
+
+	var publisher *mqttPublisher
+	if mqttBroker != "" {
+		if publisher, err = newMQTTPublisher(mqttBroker, mqttTopic, mqttClientID); err != nil {
+			log.Fatalf("Could not connect to MQTT broker %s: %v\n", mqttBroker, err)
+		}
+		onShutdown("MQTT publisher", publisher.close)
+	}
+
+	if brokers := config.SplitList(kafkaBrokers); len(brokers) > 0 {
+		producer := newKafkaProducer(brokers, kafkaTopic)
+		onRequest(producer.record)
+		onShutdown("Kafka producer", producer.close)
+	}
+
+	if natsURL != "" {
+		responder, err := newNATSResponder(natsURL, natsSubject, natsQueue)
+		if err != nil {
+			log.Fatalf("Could not subscribe to NATS subject %s: %v\n", natsSubject, err)
+		}
+		onShutdown("NATS responder", responder.close)
 	}
 
 	done := make(chan bool)
-	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	if len(restartSignals) > 0 {
+		restarts := make(chan os.Signal, 1)
+		signal.Notify(restarts, restartSignals...)
+		go handleRestarts(restarts)
+	}
 
 	go func() {
 		sig := <-quit
-		server.SetKeepAlivesEnabled(false)
-		log.Printf("Signal %v caught. Shutting down in %vs", sig, terminationDelay)
-		delay := time.NewTicker(time.Duration(terminationDelay) * time.Second)
+		// fail the readiness checks and close the connections after their current request, while
+		// still serving the requests received during the termination delay
+		atomic.StoreInt32(&shuttingDown, 1)
+		close(shutdownStarted)
+		for _, server := range servers {
+			server.SetKeepAlivesEnabled(false)
+		}
+		terminationDelay := shutdown.terminationDelay
+		if _, ok := sig.(restartSignal); ok {
+			// the new process already accepts the connections on the handed off listeners
+			terminationDelay = 0
+		}
+		log.Printf("Signal %v caught. Shutting down in %v", sig, terminationDelay)
+		drained := make(chan struct{})
+		go watchDrain(drained)
+		delay := time.NewTimer(terminationDelay)
 		defer delay.Stop()
-		select {
-		case <-quit:
-			log.Println("Second signal caught. Shutting down NOW")
-		case <-delay.C:
+		// only a second OS signal cuts the delay short: the SIGTERM the kubelet sends after a preStop
+		// hook POSTed /quitquitquit must not skip the delay the hook waited for
+		osSignals := 0
+		if isOSSignal(sig) {
+			osSignals++
+		}
+	wait:
+		for {
+			select {
+			case sig := <-quit:
+				if !isOSSignal(sig) {
+					continue
+				}
+				if osSignals++; osSignals > 1 {
+					log.Println("Second signal caught. Shutting down NOW")
+					break wait
+				}
+				log.Printf("Signal %v caught, already shutting down", sig)
+			case <-delay.C:
+				break wait
+			}
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// the servers wait for the in-flight requests to complete, until the shutdown timeout hits
+		ctx, cancel := context.WithTimeout(context.Background(), shutdown.timeout)
 		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
+		close(drainStarted)
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Could not gracefully shutdown the server, %d requests still in flight: %v", atomic.LoadInt64(&inFlight), err)
+			}
 		}
+		close(drained)
+		log.Printf("Drained with %d requests in flight", atomic.LoadInt64(&inFlight))
+		runShutdownHooks(shutdown.hooksTimeout)
 		close(done)
 	}()
 
-	cpuBurn(done, numCPUBurn)
-	log.Printf("Started server on %s", listenAddr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Could not listen on %s: %v\n", listenAddr, err)
+	burner.set(cpuBurnCount)
+	onShutdown("CPU burn", func(context.Context) error {
+		burner.set(0)
+		return nil
+	})
+	if warmupRequests > 0 {
+		if warmupURL == "" {
+			warmupURL = listeners[0].url() + "/color"
+		}
+		startWarmup(warmupURL, warmupRequests, warmupTimeout)
+	}
+	if selfTrafficRPS > 0 {
+		if selfTrafficURL == "" {
+			selfTrafficURL = listeners[0].url() + "/color"
+		}
+		log.Printf("Sending %v requests/s to %s", selfTrafficRPS, selfTrafficURL)
+		go newSelfTraffic(selfTrafficURL, selfTrafficRPS).run(done)
+	}
+	if publisher != nil {
+		go publisher.run(done)
+	}
+	for i := range listeners {
+		go listeners[i].serve(servers[i])
 	}
 
 	<-done
 	log.Println("Server stopped")
 }
 
-type colorParameters struct {
-	Color            string `json:"color"`
-	DelayProbability *int   `json:"delayPercent,omitempty"`
-	DelayLength      int    `json:"delayLength,omitempty"`
+// traced wraps the handler in a transaction named after the pattern, carrying the color and fault
+// attributes of the request. Without APM, the handler is served as is.
+func traced(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	if apmProvider == telemetry.Noop() {
+		return handler
+	}
+	wrapped := apmProvider.WrapHandler(pattern, func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		addTransactionAttributes(telemetry.FromContext(r.Context()), requestInfoFrom(w, r))
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the APM wrappers replace the response writer carrying the request info
+		wrapped(w, withRequestInfo(r, requestInfoFrom(w, r)))
+	}
+}
 
-	Return500Probability *int `json:"return500,omitempty"`
+// newColorServer returns the /color handler, serving the color of the settings with the configured
+// faults
+func newColorServer() *server.Color {
+	return server.NewColor(server.ColorConfig{
+		DecodeBody: func(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+			return decodeBody(w, r, "color", v, http.StatusInternalServerError)
+		},
+		Pick:        pickColor,
+		Faults:      faultDecider,
+		OnFaults:    injectedFaults,
+		Sleep:       tracedSleep,
+		ZoneLatency: zone.latency,
+		Upstream:    callUpstream,
+		OnServed:    servedColor,
+		Headers:     colorAnnotations,
+	})
 }
 
-func getLabels(env string) map[string]string {
-	out := make(map[string]string)
-	env = strings.Trim(env, ";\t\n\v\f\r ")
-	for _, entry := range strings.Split(env, ";") {
-		if entry == "" {
-			return nil
-		}
-		split := strings.Split(entry, ":")
-		if len(split) != 2 {
-			return nil
-		}
-		left := strings.TrimSpace(split[0])
-		right := strings.TrimSpace(split[1])
-		if left == "" || right == "" {
-			return nil
-		}
-		if utf8.RuneCountInString(left) > 255 {
-			runes := []rune(left)
-			left = string(runes[:255])
-		}
-		if utf8.RuneCountInString(right) > 255 {
-			runes := []rune(right)
-			right = string(runes[:255])
-		}
-		out[left] = right
-		if len(out) >= 64 {
-			return out
+// pickColor picks the color of a request: the override, the canary or the session color if any,
+// otherwise the color of the settings, which is then kept in the session
+func pickColor(w http.ResponseWriter, r *http.Request) server.Selection {
+	current, generation := state.get()
+	info := requestInfoFrom(w, r)
+	color, ok := overrideColor(w, r)
+	if !ok {
+		color, ok = canaryColor(w, r)
+	}
+	if !ok {
+		color, ok = sessionColor(r, current)
+	}
+	if !ok {
+		color = currentColor(current)
+		if !info.mirrored {
+			setSessionColor(w, color)
 		}
 	}
-	return out
+	if info.analysis {
+		current = analysis.apply(current)
+	}
+	return server.Selection{Color: color, Generation: generation, Faults: current.faultSettings(color)}
 }
 
-func getColor(w http.ResponseWriter, r *http.Request) {
-	requestBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(500)
-		log.Println(err.Error())
-		fmt.Fprintf(w, err.Error())
-		return
+// injectedFaults records the faults decided for a request, and reports them to the webhook and the
+// APM
+func injectedFaults(w http.ResponseWriter, r *http.Request, color string, f faults.Faults) {
+	info := requestInfoFrom(w, r)
+	info.delay, info.injectedError = f.Delay, f.Fail
+	if !info.mirrored {
+		webhook.notify("http", color, f)
 	}
+	noticeInjectedError(telemetry.FromContext(r.Context()), color, f)
+}
 
-	var request []colorParameters
-	if len(requestBody) > 0 && string(requestBody) != `"[]"` {
-		err = json.Unmarshal(requestBody, &request)
-		if err != nil {
-			w.WriteHeader(500)
-			log.Printf("%s: %v", string(requestBody), err.Error())
-			fmt.Fprintf(w, err.Error())
-			return
+// callUpstream calls the next upstream, if any, failing the response with the upstream unless the
+// fail policy falls back to the color
+func callUpstream(w http.ResponseWriter, r *http.Request, color string, status int) (int, bool) {
+	next := nextUpstream(r)
+	if next == nil {
+		return status, false
+	}
+	result := next.call(r)
+	upstreamFailPolicy.fallback(&result, color)
+	setUpstreamHeaders(w.Header(), color, result)
+	if result.failed() && !result.Fallback && status < http.StatusInternalServerError {
+		log.Printf("Upstream %s failed: status=%d error=%q", result.Name, result.Status, result.Error)
+		status = upstreamFailureStatus(result)
+		if result.TimedOut {
+			w.Header().Set("X-Upstream-Timeout", next.timeout.String())
 		}
 	}
+	return status, true
+}
 
-	colorToReturn := randomColor()
-	if color != "" {
-		colorToReturn = color
+// servedColor records the color served to a request
+func servedColor(w http.ResponseWriter, r *http.Request, color string, status int) {
+	info := requestInfoFrom(w, r)
+	info.color = color
+	if !info.mirrored {
+		colorsTotal.inc(color, statusLabel(status))
 	}
+}
 
-	var colorParams colorParameters
-	for i := range request {
-		cp := request[i]
-		if cp.Color == colorToReturn {
-			colorParams = cp
-		}
+// stats aggregates the /color responses served by /stats
+var stats = server.NewStats()
+
+// recordStats records the served /color responses in the stats
+func recordStats(event requestEvent) {
+	if event.Handler == "color" {
+		stats.Record(event.Status, event.LatencyMs)
 	}
+}
 
-	if envLatency != "" {
-		latency, err := strconv.Atoi(envLatency)
-		if err != nil {
-			w.WriteHeader(500)
-			log.Printf("%s: %v", string(requestBody), err.Error())
-			fmt.Fprintf(w, err.Error())
-			return
-		}
-		log.Printf("Delaying %s %ds", colorToReturn, latency)
-		time.Sleep(time.Duration(latency) * time.Second)
-	} else if colorParams.DelayProbability != nil && *colorParams.DelayProbability > 0 && *colorParams.DelayProbability >= rand.Intn(100) {
-		log.Printf("Delaying %s %ds", colorToReturn, colorParams.DelayLength)
-		time.Sleep(time.Duration(colorParams.DelayLength) * time.Second)
+// faultDecider decides the faults injected in the responses
+var faultDecider = faults.NewDecider(randIntn)
+
+// decideFaults decides which faults to inject in a response. The runtime settings take precedence
+// over the per-color parameters sent by the client.
+func decideFaults(current settings, colorParams server.ColorParameters) faults.Faults {
+	return faultDecider.Decide(current.faultSettings(colorParams.Color), colorParams.Faults())
+}
+
+// overrideColor returns the color forced with the color query parameter, if allowed
+func overrideColor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.URL.RawQuery == "" || !allowColorOverride {
+		return "", false
 	}
+	override := r.URL.Query().Get("color")
+	if override == "" {
+		return "", false
+	}
+	if !validColorName(override) {
+		log.Printf("Ignoring invalid color override %q", override)
+		return "", false
+	}
+	w.Header().Set("X-Color-Override", "true")
+	return override, true
+}
 
-	returnSuccess := true
-	if envErrorRate != "" {
-		errorRate, err := strconv.Atoi(envErrorRate)
-		if err != nil {
-			w.WriteHeader(500)
-			log.Printf("%s: %v", string(requestBody), err.Error())
-			fmt.Fprintf(w, err.Error())
-			return
+// validColorName returns whether the name is a plausible color, e.g. purple or 800080
+func validColorName(name string) bool {
+	if len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
 		}
-		returnSuccess = rand.Intn(100) >= errorRate
-	} else if colorParams.Return500Probability != nil && *colorParams.Return500Probability > 0 && *colorParams.Return500Probability >= rand.Intn(100) {
-		returnSuccess = false
 	}
-	printColor(colorToReturn, w, returnSuccess)
+	return true
 }
 
-func printColor(colorToPrint string, w http.ResponseWriter, healthy bool) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	if healthy {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		log.Println("Returning 500")
-		w.WriteHeader(500)
-	}
-	switch colorToPrint {
-	case "":
-		randomColor := randomColor()
-		if healthy {
-			log.Printf("Successful %s\n", randomColor)
-		} else {
-			log.Printf("500 - %s\n", randomColor)
-		}
-		fmt.Fprintf(w, "\"%s\"", randomColor)
-	default:
-		if healthy {
-			log.Printf("Successful %s\n", colorToPrint)
-		} else {
-			log.Printf("500 - %s\n", colorToPrint)
-		}
-		fmt.Fprintf(w, "\"%s\"", colorToPrint)
+// currentColor returns a color picked according to the configured weights, the color ramp, the
+// configured color, or a random one if no color is configured
+func currentColor(current settings) string {
+	if len(current.ColorWeights) > 0 {
+		return weightedColor(current.ColorWeights)
 	}
+	if ramp != nil {
+		return ramp.pick()
+	}
+	if current.Color != "" {
+		return current.Color
+	}
+	return randomColor()
 }
 
 func randomColor() string {
-	return colors[rand.Int()%len(colors)]
+	return colors[randIntn(len(colors))]
 }
 
-func cpuBurn(done <-chan bool, numCPUBurn string) {
-	if numCPUBurn == "" {
-		return
+// weightedColor picks a color with a probability proportional to its weight
+func weightedColor(weights map[string]int) string {
+	total := 0
+	for _, weight := range weights {
+		total += weight
 	}
-	var numCPU int
-	if numCPUBurn == "all" {
-		numCPU = runtime.NumCPU()
-	} else {
-		num, err := strconv.Atoi(numCPUBurn)
-		if err != nil {
-			log.Fatal(err)
+	return weightedPick(weights, randIntn(total))
+}
+
+// weightedPick returns the name matching n, between 0 and the sum of the weights, when laying out
+// the weights of the names in order
+func weightedPick(weights map[string]int, n int) string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if n < weights[name] {
+			return name
 		}
-		numCPU = num
-	}
-	log.Printf("Burning %d CPUs", numCPU)
-	noop := func() {}
-	for i := 0; i < numCPU; i++ {
-		go func(cpu int) {
-			log.Printf("Burning CPU #%d", cpu)
-			for {
-				select {
-				case <-done:
-					log.Printf("Stopped CPU burn #%d", cpu)
-					return
-				default:
-					noop()
-				}
-			}
-		}(i)
+		n -= weights[name]
 	}
+	return names[len(names)-1]
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// colorHandler is the /color route without the CORS, API key and JWT wrappers, disabled by default
+var colorHandler = instrument("color", withIdentityHeaders(traced("/color", newColorServer().ServeHTTP)))
+
+// discardLogs silences the logs of every color request until the returned function is called
+func discardLogs() func() {
+	log.SetOutput(ioutil.Discard)
+	return func() { log.SetOutput(os.Stderr) }
+}
+
+func BenchmarkColor(b *testing.B) {
+	defer discardLogs()()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/color", nil)
+		w := httptest.NewRecorder()
+		colorHandler(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkColorFaults(b *testing.B) {
+	defer discardLogs()()
+
+	params := make([]string, 0, len(colors))
+	for _, color := range colors {
+		params = append(params, fmt.Sprintf(`{"color":%q,"return500":50}`, color))
+	}
+	body := "[" + strings.Join(params, ",") + "]"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/color", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		colorHandler(w, r)
+		if w.Code != http.StatusOK && w.Code != http.StatusInternalServerError {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
+
+// discardWriter is a response writer discarding the responses, which headers are cleared on reset
+// rather than cloned on every response, unlike the ones of httptest.ResponseRecorder
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(status int)      { w.status = status }
+
+// WriteString is implemented by the response writers of net/http as well
+func (w *discardWriter) WriteString(s string) (int, error) { return len(s), nil }
+
+func (w *discardWriter) reset() {
+	for name := range w.header {
+		delete(w.header, name)
+	}
+	w.status = 0
+}
+
+func TestColorAllocs(t *testing.T) {
+	// the logs are formatted but discarded, log skipping the formatting when writing to ioutil.Discard
+	log.SetOutput(struct{ io.Writer }{ioutil.Discard})
+	defer log.SetOutput(os.Stderr)
+	defer func(listeners []func(requestEvent)) { requestListeners = listeners }(requestListeners)
+	requestListeners = []func(requestEvent){events.publish, recordStats}
+	// a fixed color, so the responses keep the same ETag
+	current, _ := state.get()
+	defer state.set(current)
+	if err := state.set(settings{Color: "blue"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &discardWriter{header: make(http.Header)}
+	etag := func() string {
+		w.reset()
+		colorHandler(w, httptest.NewRequest(http.MethodGet, "/color", nil))
+		return w.header.Get("ETag")
+	}()
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{name: "default", want: http.StatusOK},
+		{name: "json", header: http.Header{"Accept": {"application/json"}}, want: http.StatusOK},
+		{name: "text", header: http.Header{"Accept": {"text/plain"}}, want: http.StatusOK},
+		{name: "browser", header: http.Header{"Accept": {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}}, want: http.StatusOK},
+		{name: "not modified", header: http.Header{"If-None-Match": {etag}}, want: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/color", nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			w.reset()
+			colorHandler(w, r)
+			if w.status != tt.want {
+				t.Fatalf("%s: got status %d, want %d", tt.name, w.status, tt.want)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: got %v allocations per request, want 0", tt.name, allocs)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupQuotaFiles are the files of the CPU quota and period of the container, as mounted with a
+// cgroup namespace: cgroup v2 first, then the usual mount points of cgroup v1
+var cgroupQuotaFiles = []struct {
+	quota  string
+	period string
+}{
+	{quota: "/sys/fs/cgroup/cpu.max"},
+	{quota: "/sys/fs/cgroup/cpu/cpu.cfs_quota_us", period: "/sys/fs/cgroup/cpu/cpu.cfs_period_us"},
+	{quota: "/sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us", period: "/sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us"},
+}
+
+// cgroupCPULimit returns the CPU limit of the container, and false when it has none
+func cgroupCPULimit() (float64, bool, error) {
+	for _, files := range cgroupQuotaFiles {
+		content, err := ioutil.ReadFile(files.quota)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, false, err
+		}
+		// cgroup v2 holds "<quota> <period>" in a single file, cgroup v1 in two
+		fields := strings.Fields(string(content))
+		if files.period != "" {
+			period, err := ioutil.ReadFile(files.period)
+			if err != nil {
+				return 0, false, err
+			}
+			fields = append(fields, strings.TrimSpace(string(period)))
+		}
+		if len(fields) != 2 {
+			return 0, false, fmt.Errorf("unexpected content of %s: %q", files.quota, content)
+		}
+		if fields[0] == "max" || fields[0] == "-1" {
+			return 0, false, nil
+		}
+		quota, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid CPU quota in %s: %q", files.quota, fields[0])
+		}
+		period, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || period <= 0 {
+			return 0, false, fmt.Errorf("invalid CPU period for %s: %q", files.quota, fields[1])
+		}
+		return quota / period, true, nil
+	}
+	return 0, false, nil
+}
+
+// setMaxProcs sizes GOMAXPROCS to the CPU limit of the container, rounded down to at least 1, as the
+// runtime otherwise uses all the CPUs of the node and gets throttled. It is left alone when set with
+// the GOMAXPROCS environment variable or when the container has no limit.
+func setMaxProcs() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return
+	}
+	limit, ok, err := cgroupCPULimit()
+	if err != nil {
+		log.Printf("Could not read the CPU limit of the container, keeping GOMAXPROCS=%d: %v", runtime.GOMAXPROCS(0), err)
+		return
+	}
+	if !ok {
+		return
+	}
+	procs := int(limit)
+	if procs < 1 {
+		procs = 1
+	}
+	if procs < runtime.GOMAXPROCS(0) {
+		log.Printf("Setting GOMAXPROCS to %d to match the CPU limit %.2f of the container", procs, limit)
+		runtime.GOMAXPROCS(procs)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsRegistry is a minimal registry of metrics exposed in the Prometheus text exposition format
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []*metricVec
+}
+
+var registry = &metricsRegistry{}
+
+var (
+	httpRequestsTotal = newCounterVec("rollouts_demo_http_requests_total",
+		"Total number of HTTP requests served.", "handler", "code")
+	httpRequestDuration = newHistogramVec("rollouts_demo_http_request_duration_seconds",
+		"Duration of the HTTP requests served.", defaultDurationBuckets, "handler")
+	colorsTotal = newCounterVec("rollouts_demo_colors_total",
+		"Total number of colors returned.", "color", "code")
+)
+
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// metricVec is a metric partitioned by a set of label values
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+func newMetricVec(name, help, kind string, buckets []float64, labels []string) *metricVec {
+	m := &metricVec{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	registry.mu.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.mu.Unlock()
+	return m
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, counterType, nil, labels)
+}
+
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, gaugeType, nil, labels)
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	return newMetricVec(name, help, histogramType, buckets, labels)
+}
+
+// get returns the series of the given label values, creating it if needed. Must be called with the lock held.
+func (m *metricVec) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	// the key is built on the stack, the lookups of the existing series then don't allocate
+	var buf [128]byte
+	key := buf[:0]
+	for i, value := range labelValues {
+		if i > 0 {
+			key = append(key, '\xff')
+		}
+		key = append(key, value...)
+	}
+	s, ok := m.series[string(key)]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == histogramType {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[string(key)] = s
+	}
+	return s
+}
+
+// inc increments a counter or gauge by one
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+// add adds the given value to a counter or gauge
+func (m *metricVec) add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += v
+}
+
+// set sets the value of a gauge
+func (m *metricVec) set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = v
+}
+
+// observe records a value in a histogram
+func (m *metricVec) observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(labelValues)
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, strconv.Quote(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extraName, strconv.Quote(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serveMetrics writes all the registered metrics in the Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registry.mu.Lock()
+	metrics := append([]*metricVec(nil), registry.metrics...)
+	registry.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// statusRecorder captures the status code written by a handler, and carries the info of the request
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	info   requestInfo
+}
+
+// statusRecorders recycles the recorders of the instrumented requests, which must not be used once
+// the handler returned
+var statusRecorders = sync.Pool{
+	New: func() interface{} { return new(statusRecorder) },
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// WriteString writes the string without copying it, when the wrapped writer can
+func (r *statusRecorder) WriteString(s string) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return io.WriteString(r.ResponseWriter, s)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// statusLabels are the status code label values, formatted once rather than on every request
+var statusLabels = func() (labels [600]string) {
+	for code := range labels {
+		labels[code] = strconv.Itoa(code)
+	}
+	return labels
+}()
+
+// statusLabel returns the label value of a status code
+func statusLabel(code int) string {
+	if code >= 0 && code < len(statusLabels) {
+		return statusLabels[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// instrument records the request count and duration metrics of the handler and notifies the
+// request listeners. Mirrored requests are only counted in their own metric, so real and shadow
+// load can be told apart.
+func instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := statusRecorders.Get().(*statusRecorder)
+		defer statusRecorders.Put(rec)
+		*rec = statusRecorder{ResponseWriter: w}
+		info := &rec.info
+		info.mirrored = isMirrored(r)
+		info.analysis = analysis.matches(r)
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if info.mirrored {
+			mirroredRequestsTotal.inc(name, statusLabel(rec.status))
+			return
+		}
+		if info.analysis {
+			analysisRequestsTotal.inc(name)
+		}
+		duration := time.Since(start)
+		httpRequestsTotal.inc(name, statusLabel(rec.status))
+		httpRequestDuration.observe(duration.Seconds(), name)
+
+		if len(requestListeners) == 0 {
+			return
+		}
+		event := requestEvent{
+			Time:          start,
+			Handler:       name,
+			Color:         info.color,
+			Status:        rec.status,
+			LatencyMs:     float64(duration) / float64(time.Millisecond),
+			DelayMs:       float64(info.delay) / float64(time.Millisecond),
+			InjectedError: info.injectedError,
+			Subject:       info.subject,
+			Groups:        info.groups,
+		}
+		for _, listener := range requestListeners {
+			listener(event)
+		}
+	}
+}
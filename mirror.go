@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var mirroredRequestsTotal = newCounterVec("rollouts_demo_mirrored_requests_total",
+	"Total number of mirrored (shadow) HTTP requests served.", "handler", "code")
+
+// isMirrored returns whether the request is a mirrored copy of a real request, either flagged with
+// the X-Mirrored header or sent to the -shadow suffixed host used by Envoy based meshes
+func isMirrored(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("X-Mirrored"), "true") {
+		return true
+	}
+	// strips the port without net.SplitHostPort, whose error on the hosts without one allocates
+	host := r.Host
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	return strings.HasSuffix(host, "-shadow")
+}
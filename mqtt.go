@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// colorEvent is the message published to MQTT whenever the color or the health state changes
+type colorEvent struct {
+	Color        string         `json:"color"`
+	ColorWeights map[string]int `json:"colorWeights,omitempty"`
+	Healthy      bool           `json:"healthy"`
+	ErrorRate    *int           `json:"errorRate,omitempty"`
+	Latency      *int           `json:"latency,omitempty"`
+	Generation   uint64         `json:"generation"`
+	Host         string         `json:"host"`
+	Timestamp    time.Time      `json:"timestamp"`
+}
+
+// mqttPublisher publishes the color and health state changes to an MQTT topic
+type mqttPublisher struct {
+	client mqtt.Client
+	topic  string
+	host   string
+}
+
+func newMQTTPublisher(broker, topic, clientID string) (*mqttPublisher, error) {
+	host, _ := os.Hostname()
+	if clientID == "" {
+		clientID = "rollouts-demo-" + host
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectTimeout(10 * time.Second)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, token.Error()
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to MQTT broker %s, publishing to %s", broker, topic)
+	return &mqttPublisher{client: client, topic: topic, host: host}, nil
+}
+
+// run publishes the current state, then every color or health change, until stop is closed
+func (p *mqttPublisher) run(stop <-chan bool) {
+	var last *colorEvent
+	for {
+		changed := state.changes()
+		current, generation := state.get()
+		if last == nil || last.Color != current.Color || !reflect.DeepEqual(last.ColorWeights, current.ColorWeights) || last.Healthy != current.healthy() {
+			event := colorEvent{
+				Color:        current.Color,
+				ColorWeights: current.ColorWeights,
+				Healthy:      current.healthy(),
+				ErrorRate:    current.ErrorRate,
+				Latency:      current.Latency,
+				Generation:   generation,
+				Host:         p.host,
+				Timestamp:    time.Now(),
+			}
+			p.publish(event)
+			last = &event
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *mqttPublisher) publish(event colorEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	token := p.client.Publish(p.topic, 1, true, payload)
+	if token.WaitTimeout(5*time.Second) && token.Error() != nil {
+		log.Printf("Could not publish to MQTT topic %s: %v", p.topic, token.Error())
+	}
+}
+
+func (p *mqttPublisher) close(ctx context.Context) error {
+	p.client.Disconnect(250)
+	return nil
+}
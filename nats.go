@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// natsResponder subscribes to a NATS subject and replies with the current color, mirroring the
+// /color HTTP behavior. Requests may carry the same per-color parameters as the HTTP request body,
+// and the status of the reply is set in the Status-Code header.
+type natsResponder struct {
+	conn *nats.Conn
+}
+
+func newNATSResponder(url, subject, queue string) (*natsResponder, error) {
+	conn, err := nats.Connect(url, nats.Name("rollouts-demo"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	responder := &natsResponder{conn: conn}
+	if _, err := conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+		// subscription callbacks are serialized, so delays must not block the next requests
+		go responder.reply(msg)
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("Replying to NATS requests on %s (queue %s)", subject, queue)
+	return responder, nil
+}
+
+func (n *natsResponder) reply(msg *nats.Msg) {
+	if msg.Reply == "" {
+		return
+	}
+	var request []server.ColorParameters
+	if len(msg.Data) > 0 && string(msg.Data) != `"[]"` {
+		if err := json.Unmarshal(msg.Data, &request); err != nil {
+			log.Printf("%s: %v", string(msg.Data), err.Error())
+			n.respond(msg, http.StatusInternalServerError, []byte(err.Error()))
+			return
+		}
+	}
+
+	current, _ := state.get()
+	colorToReturn := currentColor(current)
+	colorParams := server.ColorParameters{Color: colorToReturn}
+	for i := range request {
+		if request[i].Color == colorToReturn {
+			colorParams = request[i]
+		}
+	}
+	f := decideFaults(current, colorParams)
+	webhook.notify("nats", colorToReturn, f)
+	if f.Delay > 0 {
+		log.Printf("Delaying %s %v", colorToReturn, f.Delay)
+		if err := tracedSleep(context.Background(), "injected delay", f.Delay); err != nil {
+			n.respond(msg, http.StatusServiceUnavailable, []byte(err.Error()))
+			return
+		}
+	}
+	status := http.StatusOK
+	if f.Fail {
+		status = http.StatusInternalServerError
+		colorsTotal.inc(colorToReturn, "500")
+		log.Printf("500 - %s\n", colorToReturn)
+	} else {
+		colorsTotal.inc(colorToReturn, "200")
+		log.Printf("Successful %s\n", colorToReturn)
+	}
+	n.respond(msg, status, []byte(strconv.Quote(colorToReturn)))
+}
+
+func (n *natsResponder) respond(msg *nats.Msg, status int, data []byte) {
+	reply := nats.NewMsg(msg.Reply)
+	reply.Header.Set("Status-Code", strconv.Itoa(status))
+	reply.Data = data
+	if err := msg.RespondMsg(reply); err != nil {
+		log.Printf("Could not reply to NATS request: %v", err)
+	}
+}
+
+// close drains the subscription, answering the pending requests
+func (n *natsResponder) close(ctx context.Context) error {
+	return n.conn.Drain()
+}
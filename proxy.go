@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+)
+
+type injectFailureKey struct{}
+
+// newColorProxy returns a reverse proxy forwarding the requests to the given upstream. Responses of
+// requests marked for failure are turned into 500s on the way back, keeping the upstream body so
+// clients can still tell which color failed.
+func newColorProxy(upstream *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.Transport = apmProvider.RoundTripper(clientFaultTransport{upstreamTransport.newTransport()})
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if fail, _ := resp.Request.Context().Value(injectFailureKey{}).(bool); fail {
+			log.Printf("Returning 500 instead of upstream %d", resp.StatusCode)
+			resp.StatusCode = http.StatusInternalServerError
+			resp.Status = http.StatusText(http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return proxy
+}
+
+// proxyColor forwards /color requests to the upstream after applying the configured faults, letting
+// the application act as a chaos proxy in front of another service
+func proxyColor(proxy *httputil.ReverseProxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, _ := state.get()
+		info := requestInfoFrom(w, r)
+		if info.analysis {
+			current = analysis.apply(current)
+		}
+		f := decideFaults(current, server.ColorParameters{})
+		info.delay, info.injectedError = f.Delay, f.Fail
+		if !info.mirrored {
+			webhook.notify("proxy", "", f)
+		}
+		noticeInjectedError(telemetry.FromContext(r.Context()), "", f)
+		if f.Delay > 0 {
+			log.Printf("Delaying proxied request %v", f.Delay)
+			if err := tracedSleep(r.Context(), "injected delay", f.Delay); err != nil {
+				server.DelayAborted(w, r, err)
+				return
+			}
+		}
+		if f.Fail {
+			r = r.WithContext(context.WithValue(r.Context(), injectFailureKey{}, true))
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// colorRamp linearly shifts the responses from one color to another over a duration, visualizing a
+// progressive rollout within a single deployment
+type colorRamp struct {
+	from     string
+	to       string
+	duration time.Duration
+	start    time.Time
+}
+
+// ramp is the color ramp defined by the COLOR_RAMP environment variable, if any
+var ramp *colorRamp
+
+// parseRamp parses a ramp definition such as "blue->green over 30m", starting the ramp now
+func parseRamp(definition string) (*colorRamp, error) {
+	invalid := fmt.Errorf("invalid color ramp %q, expected <from>-><to> over <duration>", definition)
+	split := strings.SplitN(definition, " over ", 2)
+	if len(split) != 2 {
+		return nil, invalid
+	}
+	names := strings.SplitN(split[0], "->", 2)
+	if len(names) != 2 {
+		return nil, invalid
+	}
+	r := &colorRamp{
+		from:  strings.TrimSpace(names[0]),
+		to:    strings.TrimSpace(names[1]),
+		start: time.Now(),
+	}
+	if !validColorName(r.from) || !validColorName(r.to) || r.from == "" || r.to == "" {
+		return nil, invalid
+	}
+	var err error
+	if r.duration, err = time.ParseDuration(strings.TrimSpace(split[1])); err != nil || r.duration <= 0 {
+		return nil, invalid
+	}
+	return r, nil
+}
+
+// rampFromEnv reads the color ramp from the COLOR_RAMP environment variable
+func rampFromEnv() (*colorRamp, error) {
+	definition := os.Getenv("COLOR_RAMP")
+	if definition == "" {
+		return nil, nil
+	}
+	return parseRamp(definition)
+}
+
+// progress returns the proportion, between 0 and 1, of the responses which get the target color
+func (r *colorRamp) progress(now time.Time) float64 {
+	elapsed := now.Sub(r.start)
+	if elapsed >= r.duration {
+		return 1
+	}
+	return float64(elapsed) / float64(r.duration)
+}
+
+// pick returns the target color with a probability increasing linearly with the ramp progress
+func (r *colorRamp) pick() string {
+	if randFloat64() < r.progress(time.Now()) {
+		return r.to
+	}
+	return r.from
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// randSources hands out independently seeded random generators. The global source of math/rand is
+// guarded by a mutex every color request would contend on, while the pool caches the generators per
+// processor, so the color and fault picks don't share any lock under load.
+var randSources = sync.Pool{
+	New: func() interface{} {
+		return rand.New(rand.NewSource(randSeed()))
+	},
+}
+
+// randSeed returns a seed from the system random source, falling back to the clock
+func randSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// randIntn returns a random int in [0, n)
+func randIntn(n int) int {
+	r := randSources.Get().(*rand.Rand)
+	defer randSources.Put(r)
+	return r.Intn(n)
+}
+
+// randInt63n returns a random int64 in [0, n)
+func randInt63n(n int64) int64 {
+	r := randSources.Get().(*rand.Rand)
+	defer randSources.Put(r)
+	return r.Int63n(n)
+}
+
+// randFloat64 returns a random float64 in [0.0, 1.0)
+func randFloat64() float64 {
+	r := randSources.Get().(*rand.Rand)
+	defer randSources.Put(r)
+	return r.Float64()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// BenchmarkRandIntn compares the pooled generators against the global source of math/rand, guarded
+// by a mutex, with every processor picking at once as the color handlers do under load
+func BenchmarkRandIntn(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				randIntn(100)
+			}
+		})
+	})
+	b.Run("global", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				rand.Intn(100)
+			}
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var (
+	rateLimitedTotal = newCounterVec("rollouts_demo_rate_limited_total",
+		"Total number of requests rejected by the rate limiter.")
+	clientRateLimitedTotal = newCounterVec("rollouts_demo_client_rate_limited_total",
+		"Total number of requests rejected by the per client IP rate limiter.")
+	rateLimitedClients = newGaugeVec("rollouts_demo_rate_limited_clients",
+		"Number of client IPs tracked by the per client IP rate limiter.")
+)
+
+// trustForwardedFor makes clientIP use the X-Forwarded-For header, which is only safe behind a
+// proxy overwriting it
+var trustForwardedFor bool
+
+// idleClientTimeout is the time after which the limiter of a client which stopped sending requests
+// is discarded
+const idleClientTimeout = 3 * time.Minute
+
+// parseRate parses a request rate such as 100, 100rps, 100/s or 6000/m
+func parseRate(value string) (rate.Limit, error) {
+	number, per := strings.ToLower(strings.TrimSpace(value)), 1.0
+	switch {
+	case strings.HasSuffix(number, "rps"):
+		number = strings.TrimSuffix(number, "rps")
+	case strings.HasSuffix(number, "/s"):
+		number = strings.TrimSuffix(number, "/s")
+	case strings.HasSuffix(number, "/m"):
+		number, per = strings.TrimSuffix(number, "/m"), 60
+	case strings.HasSuffix(number, "/h"):
+		number, per = strings.TrimSuffix(number, "/h"), 3600
+	}
+	requests, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || requests < 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return rate.Limit(requests / per), nil
+}
+
+// rateLimit rejects the requests exceeding the rate of the token bucket limiter with a 429, so
+// capacity-limited canaries and 429-based analysis can be demonstrated
+func rateLimit(limiter *rate.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			rateLimitedTotal.inc()
+			w.Header().Set("Retry-After", "1")
+			server.WriteError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client which sent the request: the last address of the
+// X-Forwarded-For header not belonging to the trusted proxies if any, the first address of the header
+// when trusted, the remote address otherwise
+func clientIP(r *http.Request) string {
+	host := remoteIP(r)
+	if len(trustedProxies) > 0 {
+		return forwardedClientIP(r, host)
+	}
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return host
+}
+
+// remoteIP returns the IP address of the peer of the connection
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// clientRateLimiter rate limits each client IP separately, so a noisy neighbor gets 429s while the
+// other clients keep being served
+type clientRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func newClientRateLimiter(limit rate.Limit, burst int) *clientRateLimiter {
+	return &clientRateLimiter{
+		limit:     limit,
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a request of the given client is allowed, discarding the limiters of the
+// idle clients once in a while
+func (l *clientRateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > idleClientTimeout {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > idleClientTimeout {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	rateLimitedClients.set(float64(len(l.clients)))
+	return client.limiter.AllowN(now, 1)
+}
+
+// wrap rejects the requests of the clients exceeding their rate with a 429
+func (l *clientRateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientIP(r)) {
+			clientRateLimitedTotal.inc()
+			w.Header().Set("Retry-After", "1")
+			server.WriteError(w, r, http.StatusTooManyRequests, "client rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
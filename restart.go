@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// inheritedListenersEnv lists the listeners handed off by the previous process, as
+// <network>:<address> entries matching the file descriptors starting at 3
+const inheritedListenersEnv = "INHERITED_LISTENERS"
+
+// filer is implemented by the TCP listeners and UDP connections, whose file descriptors can be
+// handed off to another process
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handoff holds the sockets opened by the process, so they can be passed to the new process on
+// restart, and the ones inherited from the previous process which are still unused
+var handoff = struct {
+	mu        sync.Mutex
+	keys      []string
+	sockets   []filer
+	inherited map[string]*os.File
+}{inherited: inheritedFiles()}
+
+// inheritedFiles returns the files of the listeners handed off by the previous process, keyed by
+// <network>:<address>
+func inheritedFiles() map[string]*os.File {
+	files := make(map[string]*os.File)
+	list := os.Getenv(inheritedListenersEnv)
+	if list == "" {
+		return files
+	}
+	os.Unsetenv(inheritedListenersEnv)
+	for i, key := range strings.Split(list, ",") {
+		files[key] = os.NewFile(uintptr(3+i), key)
+	}
+	return files
+}
+
+// takeInherited returns the file of the given inherited listener, or nil if there is none
+func takeInherited(key string) *os.File {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	f := handoff.inherited[key]
+	delete(handoff.inherited, key)
+	return f
+}
+
+// registerHandoff records a socket to hand off on restart
+func registerHandoff(key string, socket filer) {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	handoff.keys = append(handoff.keys, key)
+	handoff.sockets = append(handoff.sockets, socket)
+}
+
+// listen returns a TCP listener on the given address, inherited from the previous process if it
+// handed one off
+func listen(addr string) (net.Listener, error) {
+	key := "tcp:" + addr
+	var ln net.Listener
+	var err error
+	if f := takeInherited(key); f != nil {
+		log.Printf("Inherited listener on %s", addr)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if socket, ok := ln.(filer); ok {
+		registerHandoff(key, socket)
+	}
+	return ln, nil
+}
+
+// listenPacket returns a UDP connection on the given address, inherited from the previous process
+// if it handed one off
+func listenPacket(addr string) (net.PacketConn, error) {
+	key := "udp:" + addr
+	var conn net.PacketConn
+	var err error
+	if f := takeInherited(key); f != nil {
+		log.Printf("Inherited UDP socket on %s", addr)
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = net.ListenPacket("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if socket, ok := conn.(filer); ok {
+		registerHandoff(key, socket)
+	}
+	return conn, nil
+}
+
+// restartSignal is sent to the shutdown goroutine once a new process took over the listeners
+type restartSignal struct{}
+
+func (restartSignal) String() string { return "restart" }
+func (restartSignal) Signal()        {}
+
+// restart starts a new process of the same binary with the same arguments, handing off the
+// listening sockets so no connection is refused or reset while this process drains, e.g. to show
+// connection-preserving restarts of a single pod outside of Kubernetes
+func restart() error {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	files := make([]*os.File, 0, len(handoff.sockets))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, socket := range handoff.sockets {
+		f, err := socket.File()
+		if err != nil {
+			return fmt.Errorf("could not hand off %s: %v", handoff.keys[i], err)
+		}
+		files = append(files, f)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), inheritedListenersEnv+"="+strings.Join(handoff.keys, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started process %d with %d handed off listeners", cmd.Process.Pid, len(files))
+	return nil
+}
+
+// handleRestarts restarts the process on the restart signals, then initiates the graceful shutdown
+// of this process
+func handleRestarts(signals <-chan os.Signal) {
+	for sig := range signals {
+		log.Printf("Signal %v caught. Restarting", sig)
+		if err := restart(); err != nil {
+			log.Printf("Could not restart: %v", err)
+			continue
+		}
+		select {
+		case quit <- restartSignal{}:
+		default:
+		}
+		return
+	}
+}
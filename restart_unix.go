@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals restart the process, handing off its listeners
+var restartSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// restartSignals is empty as the listeners can't be handed off on Windows
+var restartSignals []os.Signal
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+var upstreamRetriesTotal = newCounterVec("rollouts_demo_upstream_retries_total",
+	"Total number of upstream requests retried.", "upstream")
+
+// retryPolicy retries the failed upstream requests with an exponential backoff, so retry
+// amplification effects can be shown during partial outages
+type retryPolicy struct {
+	// retries is the maximum number of retries of a request, 0 disables retries
+	retries int
+	// backoff is the delay before the first retry, doubled on every retry
+	backoff time.Duration
+	// statuses are the retryable status codes. Transport errors are always retryable.
+	statuses map[int]bool
+}
+
+var upstreamRetry = retryPolicy{backoff: 100 * time.Millisecond}
+
+// parseStatuses parses a comma separated list of status codes
+func parseStatuses(list string) (map[int]bool, error) {
+	statuses := make(map[int]bool)
+	for _, entry := range config.SplitList(list) {
+		status, err := strconv.Atoi(entry)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status code %q", entry)
+		}
+		statuses[status] = true
+	}
+	return statuses, nil
+}
+
+// retryable returns whether the result of an attempt should be retried. Requests rejected by a full
+// bulkhead are not, as retrying would only add pressure.
+func (p retryPolicy) retryable(result upstreamResult) bool {
+	return !result.Rejected && (result.Status == 0 || p.statuses[result.Status])
+}
+
+// delay returns the backoff before the given retry, starting at 1, with up to 20% of jitter
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff << uint(retry-1)
+	return d + time.Duration(randInt63n(int64(d)/5+1))
+}
+
+// sleepContext waits for the duration, returning false if the context is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// pathFileSuffixes end the _FILE variables naming files which are used as such, e.g. SSL_CERT_FILE by
+// the TLS stack of Go or NIX_SSL_CERT_FILE, and are left alone by resolveSecretFiles
+var pathFileSuffixes = []string{"CERT_FILE", "CERTS_FILE"}
+
+// pathFileVar returns whether the _FILE variable names a file used as such
+func pathFileVar(name string) bool {
+	for _, suffix := range pathFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecretFiles sets the environment variables given in files, so Kubernetes secret mounts can be
+// used instead of injecting the secrets as environment variables: every <VAR>_FILE variable, e.g.
+// NEW_RELIC_LICENSE_KEY_FILE, sets <VAR> to the content of the file it names. The _FILE variables
+// are unset once resolved, so the processes started on restart don't see both.
+func resolveSecretFiles() error {
+	for _, env := range os.Environ() {
+		fileVar := strings.SplitN(env, "=", 2)[0]
+		name := strings.TrimSuffix(fileVar, "_FILE")
+		if name == fileVar || name == "" || pathFileVar(fileVar) {
+			continue
+		}
+		file := os.Getenv(fileVar)
+		if file == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			return fmt.Errorf("both %s and %s are set", name, fileVar)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", fileVar, err)
+		}
+		os.Setenv(name, strings.TrimRight(string(content), "\r\n"))
+		os.Unsetenv(fileVar)
+		log.Printf("Read %s from %s", name, file)
+	}
+	return nil
+}
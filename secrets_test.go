@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecretFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(file, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv(map[string]string{
+		"ROLLOUTS_DEMO_TEST_SECRET_FILE": file,
+		"SSL_CERT_FILE":                  file,
+		"NIX_SSL_CERT_FILE":              file,
+	})()
+	defer os.Unsetenv("ROLLOUTS_DEMO_TEST_SECRET")
+
+	if err := resolveSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("ROLLOUTS_DEMO_TEST_SECRET"); got != "s3cr3t" {
+		t.Errorf("got ROLLOUTS_DEMO_TEST_SECRET=%q, want s3cr3t", got)
+	}
+	if _, ok := os.LookupEnv("ROLLOUTS_DEMO_TEST_SECRET_FILE"); ok {
+		t.Error("ROLLOUTS_DEMO_TEST_SECRET_FILE is still set once resolved")
+	}
+	if got := os.Getenv("SSL_CERT_FILE"); got != file {
+		t.Errorf("got SSL_CERT_FILE=%q, want it left alone", got)
+	}
+	if got := os.Getenv("NIX_SSL_CERT_FILE"); got != file {
+		t.Errorf("got NIX_SSL_CERT_FILE=%q, want it left alone", got)
+	}
+
+	os.Setenv("ROLLOUTS_DEMO_TEST_SECRET_FILE", file)
+	defer os.Unsetenv("ROLLOUTS_DEMO_TEST_SECRET_FILE")
+	if err := resolveSecretFiles(); err == nil {
+		t.Error("no error with both ROLLOUTS_DEMO_TEST_SECRET and ROLLOUTS_DEMO_TEST_SECRET_FILE set")
+	}
+}
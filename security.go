@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// securityHeaders are the security headers of the UI responses, disabled by default so the demos
+// can show a security scanner gate failing, then passing once they are enabled
+type securityHeaders struct {
+	// hstsMaxAge is the max-age of the Strict-Transport-Security header, disabled when 0
+	hstsMaxAge            time.Duration
+	contentSecurityPolicy string
+	frameOptions          string
+	referrerPolicy        string
+}
+
+// headers returns the configured headers, omitting the disabled ones
+func (s securityHeaders) headers() map[string]string {
+	headers := make(map[string]string)
+	if s.hstsMaxAge > 0 {
+		headers["Strict-Transport-Security"] = fmt.Sprintf("max-age=%d; includeSubDomains", int64(s.hstsMaxAge.Seconds()))
+	}
+	for name, value := range map[string]string{
+		"Content-Security-Policy": s.contentSecurityPolicy,
+		"X-Frame-Options":         s.frameOptions,
+		"Referrer-Policy":         s.referrerPolicy,
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// wrap adds the security headers to the handler responses
+func (s securityHeaders) wrap(next http.HandlerFunc) http.HandlerFunc {
+	headers := s.headers()
+	if len(headers) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var selfTrafficRequestsTotal = newCounterVec("rollouts_demo_self_traffic_requests_total",
+	"Total number of synthetic requests sent by the self traffic generator.", "code")
+
+// selfTraffic sends synthetic requests to the pod itself, or to its Service, so there is always
+// traffic to analyze even without an external load generator
+type selfTraffic struct {
+	target   string
+	interval time.Duration
+	client   *http.Client
+}
+
+func newSelfTraffic(target string, rps float64) *selfTraffic {
+	return &selfTraffic{
+		target:   target,
+		interval: time.Duration(float64(time.Second) / rps),
+		// the certificates are verified with the upstream TLS settings, e.g. --upstream-ca-file
+		client: &http.Client{Timeout: 30 * time.Second, Transport: upstreamTransport.newTransport()},
+	}
+}
+
+// run sends the requests at the configured rate until stopped. Requests are sent concurrently so a
+// slow response does not lower the rate.
+func (s *selfTraffic) run(stop <-chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			go s.send()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *selfTraffic) send() {
+	req, err := http.NewRequest(http.MethodGet, s.target, nil)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	req.Header.Set("User-Agent", "rollouts-demo-self-traffic")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		selfTrafficRequestsTotal.inc("error")
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxUpstreamBody))
+	resp.Body.Close()
+	selfTrafficRequestsTotal.inc(strconv.Itoa(resp.StatusCode))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// seenResult is the last result of the calls to an upstream
+type seenResult struct {
+	result upstreamResult
+	time   time.Time
+}
+
+// lastSeen keeps the last result of the calls to every upstream, from which /topology builds the
+// service graph without sending any request
+var lastSeen = struct {
+	sync.Mutex
+	results map[string]seenResult
+}{results: make(map[string]seenResult)}
+
+func recordSeen(result upstreamResult) {
+	lastSeen.Lock()
+	defer lastSeen.Unlock()
+	lastSeen.results[result.Name] = seenResult{result: result, time: time.Now()}
+}
+
+// serviceNode is a service of the graph returned by /topology
+type serviceNode struct {
+	Name         string        `json:"name" xml:"name,attr"`
+	Color        string        `json:"color,omitempty" xml:"color,omitempty"`
+	Version      string        `json:"version,omitempty" xml:"version,omitempty"`
+	Health       string        `json:"health" xml:"health"`
+	Status       int           `json:"status,omitempty" xml:"status,omitempty"`
+	LastSeen     *time.Time    `json:"lastSeen,omitempty" xml:"lastSeen,omitempty"`
+	Dependencies []serviceNode `json:"dependencies,omitempty" xml:"dependencies>service,omitempty"`
+}
+
+const (
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+	healthUnknown   = "unknown"
+)
+
+// seenNode returns the node of an upstream from its last seen result
+func seenNode(name string) serviceNode {
+	lastSeen.Lock()
+	seen, ok := lastSeen.results[name]
+	lastSeen.Unlock()
+	node := serviceNode{Name: name, Health: healthUnknown}
+	if !ok {
+		return node
+	}
+	node.Color, node.Version, node.Status = seen.result.Color, seen.result.Version, seen.result.Status
+	node.LastSeen = &seen.time
+	node.Health = healthHealthy
+	if seen.result.failed() {
+		node.Health = healthUnhealthy
+	}
+	return node
+}
+
+// chainNode returns the node of the first upstream of the chain, the next hops being nested as
+// reported in its last X-Color-Chain header
+func chainNode() serviceNode {
+	root := seenNode(upstreamChain[0].name)
+	lastSeen.Lock()
+	chain := lastSeen.results[root.Name].result.Chain
+	lastSeen.Unlock()
+	node := &root
+	for hop := 1; hop < len(chain); hop++ {
+		name := fmt.Sprintf("hop-%d", hop)
+		if hop < len(upstreamChain) {
+			name = upstreamChain[hop].name
+		}
+		node.Dependencies = []serviceNode{{Name: name, Color: chain[hop], Health: healthUnknown}}
+		node = &node.Dependencies[0]
+	}
+	return root
+}
+
+// virtualServiceNode returns the node of a virtual service of the simulated topology along with its
+// dependencies
+func virtualServiceNode(s *virtualService) serviceNode {
+	node := seenNode(s.Name)
+	if node.Color == "" {
+		node.Color = s.Color
+	}
+	for _, dependency := range s.Dependencies {
+		node.Dependencies = append(node.Dependencies, virtualServiceNode(topology.services[dependency]))
+	}
+	return node
+}
+
+// topologyResponse is the body of the /topology responses
+type topologyResponse struct {
+	XMLName xml.Name `json:"-" xml:"topology"`
+	serviceNode
+}
+
+// getTopology returns the tree of the downstream services, with their colors, versions and last
+// seen health, built from the chained calls, for rendering the service graph in the UI
+func getTopology(w http.ResponseWriter, r *http.Request) {
+	name := identity.podName
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	current, _ := state.get()
+	root := serviceNode{Name: name, Color: current.Color, Version: identity.podTemplateHash, Health: healthHealthy}
+	if !current.healthy() {
+		root.Health = healthUnhealthy
+	}
+	if len(upstreamChain) > 0 {
+		root.Dependencies = append(root.Dependencies, chainNode())
+	}
+	for _, u := range fanoutUpstreams {
+		root.Dependencies = append(root.Dependencies, seenNode(u.name))
+	}
+	if topology != nil {
+		dependencies := make(map[string]bool)
+		for _, s := range topology.Services {
+			for _, dependency := range s.Dependencies {
+				dependencies[dependency] = true
+			}
+		}
+		for _, s := range topology.Services {
+			if !dependencies[s.Name] {
+				root.Dependencies = append(root.Dependencies, virtualServiceNode(s))
+			}
+		}
+	}
+	server.WriteResponse(w, r, http.StatusOK, topologyResponse{serviceNode: root})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// sessionCookie is the name of the cookie keeping the color of a session when sticky sessions are
+// enabled. It is empty when sticky sessions are disabled.
+var sessionCookie string
+
+// canServe returns whether the color is one the current settings may return
+func canServe(current settings, color string) bool {
+	if len(current.ColorWeights) > 0 {
+		return current.ColorWeights[color] > 0
+	}
+	if ramp != nil {
+		return color == ramp.from || color == ramp.to
+	}
+	if current.Color != "" {
+		return current.Color == color
+	}
+	for _, c := range colors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionColor returns the color kept in the session cookie, if sticky sessions are enabled and the
+// color can still be served
+func sessionColor(r *http.Request, current settings) (string, bool) {
+	if sessionCookie == "" {
+		return "", false
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || !canServe(current, cookie.Value) {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// setSessionColor keeps the color served in the session cookie, so the same session keeps getting
+// the same color. Useful to demonstrate sticky canaries and cookie-based traffic routing. No new
+// session is pinned to a terminating pod.
+func setSessionColor(w http.ResponseWriter, color string) {
+	if sessionCookie == "" || atomic.LoadInt32(&shuttingDown) == 1 {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    color,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
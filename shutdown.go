@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var drainingRequests = newGaugeVec("rollouts_demo_draining_requests",
+	"Number of requests in flight during the graceful shutdown.")
+
+// drainLogInterval is the interval between the logs of the in-flight requests during the shutdown
+const drainLogInterval = time.Second
+
+// inFlight is the number of requests currently served on the user listeners
+var inFlight int64
+
+// shuttingDown is set as soon as the graceful shutdown is initiated, /readyz failing from then on so
+// the endpoints controllers stop routing to the pod while it keeps serving during the termination
+// delay
+var shuttingDown int32
+
+// shutdownStarted is closed as soon as the graceful shutdown is initiated, ending the streams
+var shutdownStarted = make(chan struct{})
+
+// drainStarted is closed when the servers start draining after the termination delay, cutting the
+// injected delays short so they don't hold the drain open
+var drainStarted = make(chan struct{})
+
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+)
+
+// shutdownConfig holds the graceful shutdown settings, which can be set with the flags or with the
+// TERMINATION_DELAY and SHUTDOWN_TIMEOUT environment variables, the environment taking precedence
+type shutdownConfig struct {
+	// terminationDelay is the delay between the termination signal and the shutdown of the servers
+	terminationDelay time.Duration
+	// timeout bounds the graceful shutdown of the servers
+	timeout time.Duration
+	// hooksTimeout bounds each shutdown hook run once the servers are stopped, e.g. the flush of the
+	// telemetry, which would otherwise get what the drain left of the shutdown timeout
+	hooksTimeout time.Duration
+
+	terminationDelaySource string
+	timeoutSource          string
+}
+
+var shutdown = shutdownConfig{
+	terminationDelay:       defaultTerminationDelay * time.Second,
+	timeout:                30 * time.Second,
+	hooksTimeout:           5 * time.Second,
+	terminationDelaySource: sourceDefault,
+	timeoutSource:          sourceDefault,
+}
+
+// resolve records which settings were set with the flags and overrides them with the environment
+// variables, so pod manifests can change them without changing the arguments
+func (c *shutdownConfig) resolve() error {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "termination-delay":
+			c.terminationDelaySource = sourceFlag
+		case "shutdown-timeout":
+			c.timeoutSource = sourceFlag
+		}
+	})
+	if delay := os.Getenv("TERMINATION_DELAY"); delay != "" {
+		d, err := config.ParseSeconds(delay)
+		if err != nil {
+			return fmt.Errorf("invalid TERMINATION_DELAY value: %s", delay)
+		}
+		c.terminationDelay, c.terminationDelaySource = d, sourceEnv
+	}
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid SHUTDOWN_TIMEOUT value: %s", timeout)
+		}
+		c.timeout, c.timeoutSource = d, sourceEnv
+	}
+	if c.terminationDelay < 0 || c.timeout <= 0 || c.hooksTimeout <= 0 {
+		return fmt.Errorf("the termination delay must not be negative and the shutdown timeouts must be positive")
+	}
+	return nil
+}
+
+// secondsValue is a duration flag also accepting a number of seconds, so the termination delay
+// keeps accepting the seconds it was historically given in
+type secondsValue time.Duration
+
+func (s *secondsValue) String() string {
+	return time.Duration(*s).String()
+}
+
+func (s *secondsValue) Set(value string) error {
+	d, err := config.ParseSeconds(value)
+	if err != nil {
+		return err
+	}
+	*s = secondsValue(d)
+	return nil
+}
+
+// configValue is an effective setting along with where it was set: default, flag or env
+type configValue struct {
+	Value  string `json:"value" xml:"value"`
+	Source string `json:"source" xml:"source"`
+}
+
+// configResponse is the body of the config responses
+type configResponse struct {
+	XMLName          xml.Name    `json:"-" xml:"config"`
+	TerminationDelay configValue `json:"terminationDelay" xml:"terminationDelay"`
+	ShutdownTimeout  configValue `json:"shutdownTimeout" xml:"shutdownTimeout"`
+}
+
+// getConfig returns the effective shutdown settings and their sources
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	server.WriteResponse(w, r, http.StatusOK, configResponse{
+		TerminationDelay: configValue{
+			Value:  shutdown.terminationDelay.String(),
+			Source: shutdown.terminationDelaySource,
+		},
+		ShutdownTimeout: configValue{Value: shutdown.timeout.String(), Source: shutdown.timeoutSource},
+	})
+}
+
+// trackInFlight counts the requests in flight, including the ones queued or shed by the limiters,
+// so the drain of the pod can be observed during the shutdown
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lameDuck asks the HTTP/1 clients to close their connection after the responses served once the
+// shutdown is initiated, so they migrate off the terminating pod faster. This doesn't rely on the
+// keep-alives being disabled, which only happens once the shutdown goroutine got to the servers.
+func lameDuck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&shuttingDown) == 1 && r.ProtoMajor == 1 {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchDrain periodically logs and exports the number of in-flight requests until stopped, making
+// the drain behavior observable during the termination delay and the shutdown of the servers
+func watchDrain(stop <-chan struct{}) {
+	ticker := time.NewTicker(drainLogInterval)
+	defer ticker.Stop()
+	drainingRequests.set(float64(atomic.LoadInt64(&inFlight)))
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n := atomic.LoadInt64(&inFlight)
+			drainingRequests.set(float64(n))
+			log.Printf("Draining: %d requests in flight", n)
+		}
+	}
+}
+
+// quit receives the signals initiating the graceful shutdown
+var quit = make(chan os.Signal, 1)
+
+// httpQuit is the signal sent by the quitquitquit endpoint
+type httpQuit struct{}
+
+func (httpQuit) String() string { return "POST /quitquitquit" }
+func (httpQuit) Signal()        {}
+
+var quitOnce sync.Once
+
+// isOSSignal returns whether the signal was sent to the process, rather than by the quitquitquit
+// endpoint or a restart
+func isOSSignal(sig os.Signal) bool {
+	switch sig.(type) {
+	case httpQuit, restartSignal:
+		return false
+	}
+	return true
+}
+
+// quitQuitQuit initiates the same graceful shutdown as SIGTERM, so preStop hooks and sidecar
+// coordination patterns can trigger the drain over HTTP. Requests must carry the QUIT_TOKEN
+// environment variable as bearer token, the endpoint being disabled when it's not set. Repeated
+// requests don't shorten the termination delay.
+func quitQuitQuit(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("QUIT_TOKEN")
+	if token == "" {
+		server.WriteError(w, r, http.StatusNotFound, "quitquitquit is disabled, set QUIT_TOKEN to enable it")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		server.WriteError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		server.WriteError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+	quitOnce.Do(func() {
+		select {
+		case quit <- httpQuit{}:
+		default:
+		}
+	})
+	server.WriteResponse(w, r, http.StatusAccepted, healthResponse{Status: "shutting down"})
+}
+
+// shutdownHook is a cleanup callback of a subsystem, run once the servers are drained
+type shutdownHook struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+var shutdownHooks struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+// onShutdown registers a cleanup callback run on shutdown. The callbacks run in the reverse order
+// of their registration (LIFO), like deferred calls, so the subsystems are stopped before the ones
+// they were started after, e.g. the producers before the telemetry registered first.
+func onShutdown(name string, run func(ctx context.Context) error) {
+	shutdownHooks.mu.Lock()
+	defer shutdownHooks.mu.Unlock()
+	shutdownHooks.hooks = append(shutdownHooks.hooks, shutdownHook{name: name, run: run})
+}
+
+// runShutdownHooks runs the registered cleanup callbacks in the reverse order of their registration,
+// each within the given timeout, logging their failures
+func runShutdownHooks(timeout time.Duration) {
+	shutdownHooks.mu.Lock()
+	defer shutdownHooks.mu.Unlock()
+	for i := len(shutdownHooks.hooks) - 1; i >= 0; i-- {
+		hook := shutdownHooks.hooks[i]
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := hook.run(ctx); err != nil {
+			log.Printf("Could not stop the %s: %v", hook.name, err)
+		}
+		cancel()
+	}
+}
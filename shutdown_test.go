@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunShutdownHooks(t *testing.T) {
+	defer func(hooks []shutdownHook) { shutdownHooks.hooks = hooks }(shutdownHooks.hooks)
+	shutdownHooks.hooks = nil
+
+	var order []string
+	hook := func(name string) {
+		onShutdown(name, func(ctx context.Context) error {
+			order = append(order, name)
+			if err := ctx.Err(); err != nil {
+				t.Errorf("%s: got an expired context: %v", name, err)
+			}
+			deadline, ok := ctx.Deadline()
+			if !ok || time.Until(deadline) <= 0 || time.Until(deadline) > time.Minute {
+				t.Errorf("%s: got deadline %v, want one within a minute", name, deadline)
+			}
+			return nil
+		})
+	}
+	hook("telemetry")
+	hook("UDP responder")
+	hook("CPU burn")
+
+	runShutdownHooks(time.Minute)
+	if want := []string{"CPU burn", "UDP responder", "telemetry"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got hooks run in order %v, want %v", order, want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+// colorAnnotations are the X-SLO-Target and X-Color-Tier headers attached to the responses of each
+// color, so log pipelines can group the requests by their intended SLO during analysis
+var colorAnnotations = map[string]http.Header{}
+
+// colorAnnotationsFromEnv reads the per-color annotations from the COLOR_SLO_TARGETS and COLOR_TIERS
+// environment variables, e.g. COLOR_SLO_TARGETS=blue:99.9,green:99.5 and
+// COLOR_TIERS=blue:stable,green:canary
+func colorAnnotationsFromEnv() (map[string]http.Header, error) {
+	annotations := make(map[string]http.Header)
+	annotate := func(color, key, value string) {
+		if annotations[color] == nil {
+			annotations[color] = make(http.Header)
+		}
+		annotations[color].Set(key, value)
+	}
+	targets, err := config.ParsePairs(os.Getenv("COLOR_SLO_TARGETS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COLOR_SLO_TARGETS value: %v", err)
+	}
+	for _, p := range targets {
+		if target, err := strconv.ParseFloat(p.Value, 64); err != nil || target < 0 || target > 100 {
+			return nil, fmt.Errorf("invalid COLOR_SLO_TARGETS target %q for %s, expected 0 to 100", p.Value, p.Key)
+		}
+		annotate(p.Key, "X-SLO-Target", p.Value)
+	}
+	tiers, err := config.ParsePairs(os.Getenv("COLOR_TIERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COLOR_TIERS value: %v", err)
+	}
+	for _, p := range tiers {
+		annotate(p.Key, "X-Color-Tier", p.Value)
+	}
+	return annotations, nil
+}
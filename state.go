@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/faults"
+)
+
+// settings are the color and fault injection settings which can be changed at runtime through the
+// admin API. Unset faults fall back to the per-color parameters sent by the clients.
+type settings struct {
+	// Color is the color returned by the application. A random color is returned when empty.
+	Color string `json:"color" xml:"color"`
+	// ColorWeights returns a weighted mix of colors, e.g. {"blue": 80, "green": 20}. Takes
+	// precedence over Color.
+	ColorWeights map[string]int `json:"colorWeights,omitempty" xml:"-"`
+	// ErrorRate is the percentage of requests failing with a 500
+	ErrorRate *int `json:"errorRate,omitempty" xml:"errorRate,omitempty"`
+	// ColorErrorRates is the percentage of requests failing with a 500 when serving each color, e.g.
+	// {"green": 50} to break the canary only. ErrorRate takes precedence.
+	ColorErrorRates map[string]int `json:"colorErrorRates,omitempty" xml:"-"`
+	// Latency is the delay, in seconds, applied to every request
+	Latency *int `json:"latency,omitempty" xml:"latency,omitempty"`
+}
+
+func (s settings) validate() error {
+	if s.Color != "" && !validColorName(s.Color) {
+		return fmt.Errorf("invalid color %q, expected up to 32 letters, digits or dashes", s.Color)
+	}
+	for color := range s.ColorWeights {
+		if !validColorName(color) {
+			return fmt.Errorf("invalid colorWeights color %q, expected up to 32 letters, digits or dashes", color)
+		}
+	}
+	if s.ErrorRate != nil && (*s.ErrorRate < 0 || *s.ErrorRate > 100) {
+		return fmt.Errorf("errorRate must be between 0 and 100, got %d", *s.ErrorRate)
+	}
+	for color, rate := range s.ColorErrorRates {
+		if !validColorName(color) || rate < 0 || rate > 100 {
+			return fmt.Errorf("invalid colorErrorRates entry %s:%d, the rate must be between 0 and 100", color, rate)
+		}
+	}
+	if s.Latency != nil && *s.Latency < 0 {
+		return fmt.Errorf("latency must not be negative, got %d", *s.Latency)
+	}
+	if err := config.ValidateWeights(s.ColorWeights); err != nil {
+		return fmt.Errorf("invalid colorWeights: %v", err)
+	}
+	return nil
+}
+
+// healthy returns whether no faults are configured in the settings
+func (s settings) healthy() bool {
+	for _, rate := range s.ColorErrorRates {
+		if rate > 0 {
+			return false
+		}
+	}
+	return (s.ErrorRate == nil || *s.ErrorRate == 0) && (s.Latency == nil || *s.Latency == 0)
+}
+
+// faultSettings returns the faults configured for the requests serving the given color
+func (s settings) faultSettings(color string) faults.Settings {
+	return faults.Settings{Latency: s.Latency, ErrorRate: s.errorRate(color)}
+}
+
+// errorRate returns the error rate of the requests serving the given color, or nil if the settings
+// leave it to the color parameters
+func (s settings) errorRate(color string) *int {
+	if s.ErrorRate != nil {
+		return s.ErrorRate
+	}
+	if rate, ok := s.ColorErrorRates[color]; ok {
+		// copied in the branch, so only the colors with an error rate allocate the returned one
+		perColor := rate
+		return &perColor
+	}
+	return nil
+}
+
+// settingsFromEnv builds the initial settings from the COLOR, COLOR_WEIGHTS, ERROR_RATE and LATENCY
+// environment variables
+func settingsFromEnv() (settings, error) {
+	s := settings{Color: os.Getenv("COLOR")}
+	if weights := os.Getenv("COLOR_WEIGHTS"); weights != "" {
+		var err error
+		if s.ColorWeights, err = config.ParseWeights(weights); err != nil {
+			return s, fmt.Errorf("invalid COLOR_WEIGHTS value %s: %v", weights, err)
+		}
+	}
+	errorRate, latency := os.Getenv("ERROR_RATE"), os.Getenv("LATENCY")
+	if errorRate != "" {
+		rate, err := strconv.Atoi(errorRate)
+		if err != nil {
+			return s, fmt.Errorf("invalid ERROR_RATE value: %s", errorRate)
+		}
+		s.ErrorRate = &rate
+	}
+	if latency != "" {
+		seconds, err := strconv.Atoi(latency)
+		if err != nil {
+			return s, fmt.Errorf("invalid LATENCY value: %s", latency)
+		}
+		s.Latency = &seconds
+	}
+	return s, s.validate()
+}
+
+// appState holds the current settings. Every update increases the generation and notifies the
+// goroutines waiting for changes.
+type appState struct {
+	mu         sync.RWMutex
+	settings   settings
+	generation uint64
+	changed    chan struct{}
+}
+
+var state = &appState{changed: make(chan struct{})}
+
+// get returns the current settings along with their generation
+func (s *appState) get() (settings, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings, s.generation
+}
+
+// set replaces the current settings
+func (s *appState) set(newSettings settings) error {
+	_, err := s.update(func(settings) settings { return newSettings })
+	return err
+}
+
+// update atomically replaces the current settings with the ones returned by the given function,
+// returning the new settings
+func (s *appState) update(change func(current settings) settings) (settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newSettings := change(s.settings)
+	if err := newSettings.validate(); err != nil {
+		return s.settings, err
+	}
+	s.settings = newSettings
+	s.generation++
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return newSettings, nil
+}
+
+// changes returns a channel which is closed on the next settings update
+func (s *appState) changes() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changed
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	rate := func(n int) *int { return &n }
+	tests := []struct {
+		name     string
+		settings settings
+		wantErr  bool
+	}{
+		{name: "empty", settings: settings{}},
+		{name: "color", settings: settings{Color: "blue"}},
+		{name: "color with digits and dashes", settings: settings{Color: "dark-blue-2"}},
+		{name: "color with markup", settings: settings{Color: "<script>alert(1)</script>"}, wantErr: true},
+		{name: "color with spaces", settings: settings{Color: "light blue"}, wantErr: true},
+		{name: "color too long", settings: settings{Color: strings.Repeat("a", 33)}, wantErr: true},
+		{name: "weights", settings: settings{ColorWeights: map[string]int{"blue": 80, "green": 20}}},
+		{name: "weights with an invalid color", settings: settings{ColorWeights: map[string]int{"blue/green": 1}}, wantErr: true},
+		{name: "weights all zero", settings: settings{ColorWeights: map[string]int{"blue": 0}}, wantErr: true},
+		{name: "error rate", settings: settings{ErrorRate: rate(100)}},
+		{name: "error rate above 100", settings: settings{ErrorRate: rate(101)}, wantErr: true},
+		{name: "negative error rate", settings: settings{ErrorRate: rate(-1)}, wantErr: true},
+		{name: "color error rates", settings: settings{ColorErrorRates: map[string]int{"green": 50}}},
+		{name: "color error rate of an invalid color", settings: settings{ColorErrorRates: map[string]int{"gr een": 50}}, wantErr: true},
+		{name: "negative latency", settings: settings{Latency: rate(-1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.settings.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
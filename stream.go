@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+var streamSubscribers = newGaugeVec("rollouts_demo_event_stream_subscribers",
+	"Number of clients subscribed to the request event stream.")
+
+const (
+	// streamBuffer is the number of events buffered for each subscriber, the events being dropped
+	// for the subscribers too slow to keep up
+	streamBuffer = 256
+	// streamHeartbeat is the interval of the comments keeping the idle streams open through proxies
+	streamHeartbeat = 15 * time.Second
+)
+
+// eventStream broadcasts the request events to the subscribed clients, so the UI can render the
+// responses of all the clients in real time
+type eventStream struct {
+	mu          sync.Mutex
+	subscribers map[chan requestEvent]struct{}
+}
+
+var events = &eventStream{subscribers: make(map[chan requestEvent]struct{})}
+
+// publish sends the event to the subscribers without blocking
+func (s *eventStream) publish(event requestEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (s *eventStream) subscribe() chan requestEvent {
+	ch := make(chan requestEvent, streamBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[ch] = struct{}{}
+	streamSubscribers.set(float64(len(s.subscribers)))
+	return ch
+}
+
+func (s *eventStream) unsubscribe(ch chan requestEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+	streamSubscribers.set(float64(len(s.subscribers)))
+}
+
+// streamEvents streams the request events as server-sent events, until the client disconnects or
+// the shutdown starts, the client then reconnecting to another pod
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		server.WriteError(w, r, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: request\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-shutdownStarted:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
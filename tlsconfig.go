@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// tlsSettings are the protocol settings of the TLS listeners, so compliance profiles can be served
+// and validated with a scanner
+type tlsSettings struct {
+	minVersion   string
+	cipherSuites string
+	curves       string
+}
+
+// config returns the TLS configuration of the listeners. The cipher suites only apply up to TLS 1.2,
+// the TLS 1.3 ones not being configurable.
+func (s tlsSettings) config() (*tls.Config, error) {
+	version, ok := tlsVersions[s.minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS minimum version %s, expected 1.0, 1.1, 1.2 or 1.3", s.minVersion)
+	}
+	c := &tls.Config{MinVersion: version}
+	if names := config.SplitList(s.cipherSuites); len(names) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range names {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %s", name)
+			}
+			c.CipherSuites = append(c.CipherSuites, id)
+		}
+	}
+	for _, name := range config.SplitList(s.curves) {
+		curve, ok := tlsCurves[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %s, expected X25519, P256, P384 or P521", name)
+		}
+		c.CurvePreferences = append(c.CurvePreferences, curve)
+	}
+	return c, nil
+}
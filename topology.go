@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/faults"
+	"github.com/argoproj/rollouts-demo/internal/server"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var virtualServiceRequestsTotal = newCounterVec("rollouts_demo_virtual_service_requests_total",
+	"Total number of requests served by the virtual services of the topology.", "service", "code")
+
+// virtualService is a service of a simulated topology, with its own color, faults and dependencies
+type virtualService struct {
+	Name         string        `yaml:"name"`
+	Color        string        `yaml:"color"`
+	ErrorRate    int           `yaml:"errorRate"`
+	Latency      time.Duration `yaml:"latency"`
+	Dependencies []string      `yaml:"dependencies"`
+
+	upstreams []*upstreamService
+}
+
+// topologyConfig describes the virtual services simulated by a single process, letting a small
+// service graph be demoed for tracing and analysis. The virtual services call their dependencies
+// through the user listener, so every hop is a real HTTP request.
+type topologyConfig struct {
+	Services []*virtualService `yaml:"services"`
+
+	services map[string]*virtualService
+}
+
+// topology is nil when no topology is simulated
+var topology *topologyConfig
+
+// loadTopology reads a topology file. The dependencies are called through the given base URL, the
+// address of the user listener.
+func loadTopology(path, baseURL string) (*topologyConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &topologyConfig{}
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %v", path, err)
+	}
+	t.services = make(map[string]*virtualService, len(t.Services))
+	for _, s := range t.Services {
+		if s.Name == "" || !validColorName(s.Name) {
+			return nil, fmt.Errorf("invalid topology %s: invalid service name %q", path, s.Name)
+		}
+		if _, ok := t.services[s.Name]; ok {
+			return nil, fmt.Errorf("invalid topology %s: duplicate service %s", path, s.Name)
+		}
+		if !validColorName(s.Color) {
+			return nil, fmt.Errorf("invalid topology %s: invalid color %q of service %s", path, s.Color, s.Name)
+		}
+		if s.ErrorRate < 0 || s.ErrorRate > 100 || s.Latency < 0 {
+			return nil, fmt.Errorf("invalid topology %s: invalid faults of service %s", path, s.Name)
+		}
+		t.services[s.Name] = s
+	}
+	for _, s := range t.Services {
+		for _, dependency := range s.Dependencies {
+			if _, ok := t.services[dependency]; !ok {
+				return nil, fmt.Errorf("invalid topology %s: unknown dependency %s of service %s", path, dependency, s.Name)
+			}
+			u, err := newUpstreamService(dependency, strings.TrimSuffix(baseURL, "/")+"/services/"+dependency+"/color")
+			if err != nil {
+				return nil, err
+			}
+			s.upstreams = append(s.upstreams, u)
+		}
+	}
+	if cycle := t.findCycle(); cycle != "" {
+		return nil, fmt.Errorf("invalid topology %s: dependency cycle %s", path, cycle)
+	}
+	return t, nil
+}
+
+// findCycle returns the first dependency cycle found, e.g. a->b->a, or an empty string
+func (t *topologyConfig) findCycle() string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(name string, path []string) string
+	visit = func(name string, path []string) string {
+		path = append(path, name)
+		switch marks[name] {
+		case visiting:
+			return strings.Join(path, "->")
+		case visited:
+			return ""
+		}
+		marks[name] = visiting
+		for _, dependency := range t.services[name].Dependencies {
+			if cycle := visit(dependency, path); cycle != "" {
+				return cycle
+			}
+		}
+		marks[name] = visited
+		return ""
+	}
+	for _, s := range t.Services {
+		if cycle := visit(s.Name, nil); cycle != "" {
+			return cycle
+		}
+	}
+	return ""
+}
+
+// serveVirtualService serves the /services/<name>/color requests of the virtual services: the
+// service faults are injected and its dependencies called in parallel
+func serveVirtualService(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"), "/")
+	if topology == nil || len(split) != 2 || split[1] != "color" {
+		server.WriteError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	s, ok := topology.services[split[0]]
+	if !ok {
+		server.WriteError(w, r, http.StatusNotFound, fmt.Sprintf("unknown service %s", split[0]))
+		return
+	}
+	w.Header().Set("X-Service", s.Name)
+	color := s.Color
+	if color == "" {
+		color = randomColor()
+	}
+	info := requestInfoFrom(w, r)
+	info.color = color
+	if s.Latency > 0 {
+		info.delay = s.Latency
+		if err := tracedSleep(r.Context(), "injected delay", s.Latency); err != nil {
+			server.DelayAborted(w, r, err)
+			return
+		}
+	}
+
+	results := make([]upstreamResult, len(s.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range s.upstreams {
+		wg.Add(1)
+		go func(i int, u *upstreamService, r *http.Request) {
+			defer wg.Done()
+			result := u.call(r)
+			upstreamFailPolicy.fallback(&result, color)
+			results[i] = result
+		}(i, u, forGoroutine(r))
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if randIntn(100) < s.ErrorRate {
+		info.injectedError = true
+		status = http.StatusInternalServerError
+		noticeInjectedError(telemetry.FromContext(r.Context()), color, faults.Faults{Fail: true, ErrorRate: s.ErrorRate, ErrorSource: "topology"})
+	}
+	for _, result := range results {
+		if status == http.StatusOK && result.failed() && !result.Fallback {
+			log.Printf("Dependency %s of %s failed: status=%d error=%q", result.Name, s.Name, result.Status, result.Error)
+			status = upstreamFailureStatus(result)
+		}
+	}
+	virtualServiceRequestsTotal.inc(s.Name, statusLabel(status))
+	server.WriteResponse(w, r, status, &server.ColorResponse{Color: color})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+var upstreamConnectionsTotal = newCounterVec("rollouts_demo_upstream_connections_total",
+	"Total number of connections used by the requests sent to the upstreams, by whether they were reused from the pool.", "upstream", "reused")
+
+// upstreamTransportConfig tunes the connection pools and TLS settings of the HTTP clients sending
+// the outbound requests, so connection pool effects under canary shifts can be studied
+type upstreamTransportConfig struct {
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	caFile              string
+	certFile            string
+	keyFile             string
+	insecureSkipVerify  bool
+
+	tlsConfig *tls.Config
+}
+
+var upstreamTransport = upstreamTransportConfig{
+	maxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+	idleConnTimeout:     90 * time.Second,
+}
+
+// loadTLS builds the TLS settings from the CA and client certificate files
+func (c *upstreamTransportConfig) loadTLS() error {
+	if c.caFile == "" && c.certFile == "" && c.keyFile == "" && !c.insecureSkipVerify {
+		return nil
+	}
+	config := &tls.Config{InsecureSkipVerify: c.insecureSkipVerify}
+	if c.caFile != "" {
+		pool, err := loadCAFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("invalid upstream CA file: %v", err)
+		}
+		config.RootCAs = pool
+	}
+	if c.certFile != "" || c.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return fmt.Errorf("could not load the upstream client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	c.tlsConfig = config
+	return nil
+}
+
+// loadCAFile returns the pool of the CA certificates of a PEM file
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// newTransport returns a transport with the configured connection pool and TLS settings
+func (c upstreamTransportConfig) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+	transport.IdleConnTimeout = c.idleConnTimeout
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig.Clone()
+	}
+	return transport
+}
+
+// traceConnections counts the connections used by the request in the upstream connection metric
+func traceConnections(req *http.Request, upstream string) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnectionsTotal.inc(upstream, strconv.FormatBool(info.Reused))
+		},
+	}))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net"
+)
+
+var udpDatagramsTotal = newCounterVec("rollouts_demo_udp_datagrams_total",
+	"Total number of UDP datagrams answered.", "color")
+
+// serveUDP replies to any datagram received on the given address with the current color. The
+// listener stops when the returned connection is closed.
+func serveUDP(addr string) (net.PacketConn, error) {
+	conn, err := listenPacket(addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Started UDP responder on %s", addr)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, remote, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.Printf("UDP responder stopped: %v", err)
+				return
+			}
+			current, _ := state.get()
+			colorToReturn := currentColor(current)
+			if _, err := conn.WriteTo([]byte(colorToReturn+"\n"), remote); err != nil {
+				log.Printf("Could not reply to %s: %v", remote, err)
+				continue
+			}
+			udpDatagramsTotal.inc(colorToReturn)
+		}
+	}()
+	return conn, nil
+}
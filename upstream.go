@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+	"github.com/argoproj/rollouts-demo/internal/telemetry"
+	"google.golang.org/grpc"
+)
+
+var (
+	upstreamRequestsTotal = newCounterVec("rollouts_demo_upstream_requests_total",
+		"Total number of requests sent to the upstream color services.", "upstream", "code")
+	upstreamRequestDuration = newHistogramVec("rollouts_demo_upstream_request_duration_seconds",
+		"Duration of the requests sent to the upstream color services.", defaultDurationBuckets, "upstream")
+)
+
+// maxUpstreamBody limits the size of the upstream color responses
+const maxUpstreamBody = 4 << 10
+
+// traceHeaders are the trace context headers forwarded to the upstreams, so traces started by a
+// mesh or an OpenTelemetry instrumented client keep covering the whole chain. The New Relic trace
+// context replaces the W3C headers when a transaction is in progress.
+var traceHeaders = []string{
+	"traceparent",
+	"tracestate",
+	"b3",
+	"x-b3-traceid",
+	"x-b3-spanid",
+	"x-b3-parentspanid",
+	"x-b3-sampled",
+	"x-b3-flags",
+	"x-request-id",
+	"x-ot-span-context",
+}
+
+// upstreamService is a color service called by /color in chaining mode, so multi-tier rollout
+// demos (e.g. frontend->backend) work with a single image
+type upstreamService struct {
+	name     string
+	url      string
+	timeout  time.Duration
+	client   *http.Client
+	bulkhead *bulkhead
+	// conn is the connection to a gRPC upstream, nil for HTTP upstreams
+	conn *grpc.ClientConn
+}
+
+// upstreamTimeouts are the default timeouts of the upstream requests, which can be overridden per
+// upstream
+var upstreamTimeouts = struct {
+	request time.Duration
+	connect time.Duration
+}{
+	request: 10 * time.Second,
+	connect: 2 * time.Second,
+}
+
+// upstreamChain are the services traversed by a request in chaining mode: the service at the Nth
+// hop of the chain calls the Nth upstream. Every service of the chain is expected to be configured
+// with the same chain, so the route never depends on the request content.
+var upstreamChain []*upstreamService
+
+// maxChainDepth bounds the number of hops of a chain
+const maxChainDepth = 32
+
+// upstreamChainFromEnv builds the chain from the UPSTREAM_URL environment variable, a comma separated
+// list of upstream URLs, and the CHAIN_DEPTH environment variable, which repeats a single upstream
+// so services calling themselves (e.g. through their Service) traverse that many hops
+func upstreamChainFromEnv() ([]*upstreamService, error) {
+	urls := config.SplitList(os.Getenv("UPSTREAM_URL"))
+	if depth := os.Getenv("CHAIN_DEPTH"); depth != "" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 1 || n > maxChainDepth {
+			return nil, fmt.Errorf("invalid CHAIN_DEPTH value, expected 1 to %d: %s", maxChainDepth, depth)
+		}
+		if len(urls) != 1 {
+			return nil, fmt.Errorf("CHAIN_DEPTH requires a single UPSTREAM_URL")
+		}
+		for len(urls) < n {
+			urls = append(urls, urls[0])
+		}
+	}
+	if len(urls) > maxChainDepth {
+		return nil, fmt.Errorf("UPSTREAM_URL must not list more than %d upstreams", maxChainDepth)
+	}
+	chain := make([]*upstreamService, 0, len(urls))
+	for i, spec := range urls {
+		if i > 0 && spec == urls[i-1] {
+			chain = append(chain, chain[i-1])
+			continue
+		}
+		u, err := newUpstreamService("", spec)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, u)
+	}
+	return chain, nil
+}
+
+// chainHop returns the hop of the chain serving the request, 0 for the edge service
+func chainHop(r *http.Request) int {
+	value := r.Header.Get("X-Chain-Hop")
+	if value == "" {
+		return 0
+	}
+	hop, err := strconv.Atoi(value)
+	if err != nil || hop < 0 {
+		return 0
+	}
+	return hop
+}
+
+// nextUpstream returns the upstream to call while serving the request, or nil at the end of the
+// chain
+func nextUpstream(r *http.Request) *upstreamService {
+	if hop := chainHop(r); hop < len(upstreamChain) {
+		return upstreamChain[hop]
+	}
+	return nil
+}
+
+// newUpstreamService returns an upstream calling the given URL, defaulting to its /color endpoint,
+// or the gRPC color service for grpc:// URLs.
+// The URL can be followed by semicolon separated options overriding the default timeouts and
+// bulkhead size, e.g. http://backend:8080;timeout=2s;connect-timeout=500ms;max-concurrency=10. The
+// upstream is named after the URL host unless a name is given.
+func newUpstreamService(name, spec string) (*upstreamService, error) {
+	options := strings.Split(spec, ";")
+	u, err := url.Parse(strings.TrimSpace(options[0]))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "grpc") {
+		return nil, fmt.Errorf("invalid upstream URL %q", options[0])
+	}
+	if (u.Path == "" || u.Path == "/") && u.Scheme != "grpc" {
+		u.Path = "/color"
+	}
+	timeout, connectTimeout := upstreamTimeouts.request, upstreamTimeouts.connect
+	maxConcurrency := upstreamMaxConcurrency
+	for _, option := range options[1:] {
+		split := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid upstream option %q for %s", option, u.Host)
+		}
+		key, value := split[0], split[1]
+		switch key {
+		case "timeout", "connect-timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid upstream %s %q for %s", key, value, u.Host)
+			}
+			if key == "timeout" {
+				timeout = d
+			} else {
+				connectTimeout = d
+			}
+		case "max-concurrency":
+			if maxConcurrency, err = strconv.Atoi(value); err != nil || maxConcurrency < 0 {
+				return nil, fmt.Errorf("invalid upstream %s %q for %s", key, value, u.Host)
+			}
+		default:
+			return nil, fmt.Errorf("unknown upstream option %q for %s", key, u.Host)
+		}
+	}
+	transport := upstreamTransport.newTransport()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.MaxConnsPerHost = maxConcurrency
+	if name == "" {
+		name = u.Host
+	}
+	upstream := &upstreamService{
+		name:     name,
+		url:      u.String(),
+		timeout:  timeout,
+		client:   &http.Client{Timeout: timeout, Transport: apmProvider.RoundTripper(clientFaultTransport{transport})},
+		bulkhead: newBulkhead(name, maxConcurrency),
+	}
+	if u.Scheme == "grpc" {
+		if upstream.conn, err = dialGRPC(u.Host, connectTimeout); err != nil {
+			return nil, fmt.Errorf("invalid gRPC upstream %s: %v", u.Host, err)
+		}
+	}
+	return upstream, nil
+}
+
+// upstreamResult is the outcome of a call to an upstream color service
+type upstreamResult struct {
+	Name      string   `json:"name" xml:"name"`
+	Color     string   `json:"color,omitempty" xml:"color,omitempty"`
+	Chain     []string `json:"chain,omitempty" xml:"chain>color,omitempty"`
+	Status    int      `json:"status,omitempty" xml:"status,omitempty"`
+	Version   string   `json:"version,omitempty" xml:"version,omitempty"`
+	Attempts  int      `json:"attempts" xml:"attempts"`
+	LatencyMs float64  `json:"latencyMs" xml:"latencyMs"`
+	Error     string   `json:"error,omitempty" xml:"error,omitempty"`
+	TimedOut  bool     `json:"timedOut,omitempty" xml:"timedOut,omitempty"`
+	Fallback  bool     `json:"fallback,omitempty" xml:"fallback,omitempty"`
+	Rejected  bool     `json:"rejected,omitempty" xml:"rejected,omitempty"`
+}
+
+// failed returns whether the upstream call failed or returned a server error
+func (res upstreamResult) failed() bool {
+	return res.Error != "" || res.Status >= http.StatusInternalServerError
+}
+
+// call requests the color of the upstream on behalf of the request being served, retrying the
+// failed attempts according to the retry policy. The call and its retry backoffs are traced in
+// segments, the attempts in external segments.
+func (u *upstreamService) call(r *http.Request) upstreamResult {
+	txn := telemetry.FromContext(r.Context())
+	defer txn.StartSegment("upstream " + u.name).End()
+	start := time.Now()
+	var result upstreamResult
+	for attempt := 1; ; attempt++ {
+		result = u.hedgedAttempt(r)
+		result.Attempts = attempt
+		if attempt > upstreamRetry.retries || !upstreamRetry.retryable(result) {
+			break
+		}
+		backoff := txn.StartSegment("upstream retry backoff")
+		slept := sleepContext(r.Context(), upstreamRetry.delay(attempt))
+		backoff.End()
+		if !slept {
+			break
+		}
+		upstreamRetriesTotal.inc(u.name)
+	}
+	result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	recordSeen(result)
+	return result
+}
+
+// attempt sends a single request to the upstream, unless its bulkhead is full. Requests canceled by
+// the client or by hedging are counted with the canceled code.
+func (u *upstreamService) attempt(r *http.Request) upstreamResult {
+	if !u.bulkhead.acquire() {
+		upstreamRequestsTotal.inc(u.name, "rejected")
+		return upstreamResult{Name: u.name, Error: "bulkhead full", Rejected: true}
+	}
+	defer u.bulkhead.release()
+	start := time.Now()
+	result := u.do(r)
+	duration := time.Since(start)
+	code := "error"
+	if result.Status != 0 {
+		code = strconv.Itoa(result.Status)
+	} else if r.Context().Err() == context.Canceled {
+		code = "canceled"
+	}
+	upstreamRequestsTotal.inc(u.name, code)
+	upstreamRequestDuration.observe(duration.Seconds(), u.name)
+	return result
+}
+
+func (u *upstreamService) do(r *http.Request) upstreamResult {
+	if u.conn != nil {
+		return u.doGRPC(r)
+	}
+	result := upstreamResult{Name: u.name}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Chain-Hop", strconv.Itoa(chainHop(r)+1))
+	for _, name := range append([]string{"X-User-Id", "X-Mirrored"}, traceHeaders...) {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := u.client.Do(traceConnections(req, u.name))
+	if err != nil {
+		var netErr net.Error
+		result.TimedOut = errors.As(err, &netErr) && netErr.Timeout()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+	result.Version = resp.Header.Get("X-Pod-Template-Hash")
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUpstreamBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := json.Unmarshal(body, &result.Color); err != nil {
+		result.Error = fmt.Sprintf("invalid color response: %v", err)
+		return result
+	}
+	if result.Chain = config.SplitList(resp.Header.Get("X-Color-Chain")); len(result.Chain) == 0 {
+		result.Chain = []string{result.Color}
+	}
+	return result
+}
+
+// forGoroutine returns the request to use in a new goroutine, with its own handle of the
+// transaction as required to record concurrent segments
+func forGoroutine(r *http.Request) *http.Request {
+	txn := telemetry.FromContext(r.Context()).NewGoroutine()
+	return r.WithContext(telemetry.NewContext(r.Context(), txn))
+}
+
+// setUpstreamHeaders reports the local and upstream colors in the response headers. X-Color-Chain
+// lists the colors of every service of the chain, starting with the local one.
+func setUpstreamHeaders(header http.Header, localColor string, result upstreamResult) {
+	header.Set("X-Color-Chain", strings.Join(append([]string{localColor}, result.Chain...), ","))
+	if result.Color != "" {
+		header.Set("X-Upstream-Color", result.Color)
+	}
+	if result.Status != 0 {
+		header.Set("X-Upstream-Status", strconv.Itoa(result.Status))
+	}
+	header.Set("X-Upstream-Latency", fmt.Sprintf("%.3fms", result.LatencyMs))
+	header.Set("X-Upstream-Fail-Policy", string(upstreamFailPolicy))
+	if result.Fallback {
+		header.Set("X-Upstream-Fallback", "true")
+	}
+}
+
+// upstreamFailureStatus returns the status of a response whose upstream failed: a 504 when the
+// upstream timed out, a 502 otherwise
+func upstreamFailureStatus(result upstreamResult) int {
+	if result.TimedOut {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
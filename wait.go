@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// waitColor is a long-polling endpoint which blocks until the color changes, or the timeout expires,
+// and then returns the current color. The color known by the client can be passed in the color query
+// parameter, otherwise the color at the time of the request is used.
+func waitColor(color *server.Color) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := defaultWaitTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			var err error
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+				server.WriteError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid timeout %q: must be a duration between 0 and %v", value, maxWaitTimeout))
+				return
+			}
+		}
+
+		current, _ := state.get()
+		known := current.Color
+		if r.URL.Query().Get("color") != "" {
+			known = r.URL.Query().Get("color")
+		}
+
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+		for current.Color == known {
+			changed := state.changes()
+			if current, _ = state.get(); current.Color != known {
+				break
+			}
+			select {
+			case <-changed:
+				current, _ = state.get()
+			case <-deadline.C:
+				w.Header().Set("X-Color-Changed", "false")
+				color.WriteColor(w, r, currentColor(current), http.StatusOK)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("X-Color-Changed", "true")
+		color.WriteColor(w, r, currentColor(current), http.StatusOK)
+	}
+}
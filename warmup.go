@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/server"
+)
+
+// warmupConcurrency is the number of warm-up requests sent in parallel, opening as many connections
+// in the pools
+const warmupConcurrency = 4
+
+// warmedUp is set once the warm-up requests have been sent, /readyz failing until then
+var warmedUp int32 = 1
+
+// readyResponse is the body of the readiness check responses
+type readyResponse struct {
+	XMLName xml.Name `json:"-" xml:"ready"`
+	Status  string   `json:"status" xml:"status"`
+}
+
+func (r readyResponse) String() string {
+	return r.Status
+}
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		server.WriteResponse(w, r, http.StatusServiceUnavailable, readyResponse{Status: "shutting down"})
+		return
+	}
+	if atomic.LoadInt32(&warmedUp) == 0 {
+		server.WriteResponse(w, r, http.StatusServiceUnavailable, readyResponse{Status: "warming up"})
+		return
+	}
+	server.WriteResponse(w, r, http.StatusOK, readyResponse{Status: "ok"})
+}
+
+// startWarmup sends the given number of requests to the target in the background, /readyz failing
+// until they are done. This warms the connection pools and caches of the process and of its upstreams
+// so the first user requests don't show cold-start latency. The requests are flagged as mirrored,
+// keeping them out of the regular metrics.
+func startWarmup(target string, requests int, timeout time.Duration) {
+	atomic.StoreInt32(&warmedUp, 0)
+	go func() {
+		defer atomic.StoreInt32(&warmedUp, 1)
+		warmup(target, requests, timeout)
+	}()
+}
+
+// warmup sends the warm-up requests. Failed requests are retried until the timeout, as the listeners
+// may not be serving yet.
+func warmup(target string, requests int, timeout time.Duration) {
+	// the certificates are verified with the upstream TLS settings, e.g. --upstream-ca-file
+	client := &http.Client{Timeout: timeout, Transport: upstreamTransport.newTransport()}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	var sent int64
+	var wg sync.WaitGroup
+	for i := 0; i < warmupConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&sent, 1) <= int64(requests) {
+				for {
+					err := sendWarmup(client, target)
+					if err == nil {
+						break
+					}
+					if time.Now().After(deadline) {
+						return
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if time.Now().After(deadline) {
+		log.Printf("Warm-up timed out after %v", timeout)
+		return
+	}
+	log.Printf("Warmed up with %d requests in %v", requests, time.Since(start).Round(time.Millisecond))
+}
+
+func sendWarmup(client *http.Client, target string) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Mirrored", "true")
+	req.Header.Set("User-Agent", "rollouts-demo-warmup")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxUpstreamBody))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("warm-up request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/faults"
+)
+
+var webhookEventsTotal = newCounterVec("rollouts_demo_fault_webhook_events_total",
+	"Total number of fault events sent to the webhook.", "result")
+
+// faultEvent is posted to the fault webhook whenever an error or a delay is injected
+type faultEvent struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Color   string    `json:"color,omitempty"`
+	Error   bool      `json:"error"`
+	DelayMs int64     `json:"delayMs,omitempty"`
+	Host    string    `json:"host"`
+}
+
+// faultWebhook posts the fault events to a webhook in the background, so external systems can react
+// to the injected failures. Events are dropped when the webhook can't keep up.
+type faultWebhook struct {
+	url    string
+	host   string
+	client *http.Client
+	events chan faultEvent
+}
+
+// webhook is nil when no fault webhook is configured
+var webhook *faultWebhook
+
+func newFaultWebhook(url string) *faultWebhook {
+	host, _ := os.Hostname()
+	w := &faultWebhook{
+		url:    url,
+		host:   host,
+		client: &http.Client{Timeout: 5 * time.Second},
+		events: make(chan faultEvent, 100),
+	}
+	go w.run()
+	log.Printf("Posting fault events to %s", url)
+	return w
+}
+
+// notify queues a fault event if any fault was injected
+func (w *faultWebhook) notify(source, color string, f faults.Faults) {
+	if w == nil || (!f.Fail && f.Delay == 0) {
+		return
+	}
+	event := faultEvent{
+		Time:    time.Now(),
+		Source:  source,
+		Color:   color,
+		Error:   f.Fail,
+		DelayMs: int64(f.Delay / time.Millisecond),
+		Host:    w.host,
+	}
+	select {
+	case w.events <- event:
+	default:
+		webhookEventsTotal.inc("dropped")
+	}
+}
+
+func (w *faultWebhook) run() {
+	for event := range w.events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Could not post fault event to %s: %v", w.url, err)
+			webhookEventsTotal.inc("error")
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Fault webhook %s returned %d", w.url, resp.StatusCode)
+			webhookEventsTotal.inc("error")
+			continue
+		}
+		webhookEventsTotal.inc("sent")
+	}
+}
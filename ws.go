@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds the writes to the WebSocket clients, so a stalled client can't block its
+// stream forever
+const wsWriteTimeout = 5 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// streamPod is the identity of the pod serving a stream, so the clients can tell when they got
+// reconnected to another pod
+type streamPod struct {
+	Name            string `json:"name,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	RolloutRole     string `json:"rolloutRole,omitempty"`
+	PodTemplateHash string `json:"podTemplateHash,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+}
+
+// streamMessage is a message of the WebSocket stream: the hello message describing the pod, sent
+// first, then a request message for every served request
+type streamMessage struct {
+	Type    string        `json:"type"`
+	Pod     *streamPod    `json:"pod,omitempty"`
+	Request *requestEvent `json:"request,omitempty"`
+}
+
+// streamWebSocket streams the request events over a WebSocket, until the client disconnects or the
+// shutdown starts. The connection is then closed with the going away code, so the clients reconnect
+// to another pod, demonstrating the WebSocket connection handling across pod restarts.
+func streamWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// the client messages are discarded, reading them is needed to process the control messages
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	hello := streamMessage{Type: "hello", Pod: &streamPod{
+		Name:            identity.podName,
+		Namespace:       identity.podNamespace,
+		RolloutRole:     identity.rolloutRole,
+		PodTemplateHash: identity.podTemplateHash,
+		Zone:            identity.zone,
+	}}
+	if err := writeStreamMessage(conn, hello); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-ch:
+			if err := writeStreamMessage(conn, streamMessage{Type: "request", Request: &event}); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-shutdownStarted:
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down")
+			if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout)); err != nil {
+				log.Printf("Could not close the WebSocket: %v", err)
+			}
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+func writeStreamMessage(conn *websocket.Conn, message streamMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(message)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/argoproj/rollouts-demo/internal/config"
+)
+
+// zoneConfig is the behavior of the pods running in the zone of this pod, enabling multi-zone
+// rollout and locality routing demos
+type zoneConfig struct {
+	// color is the default color of the zone
+	color string
+	// latency is added to every color response, e.g. to simulate a distant zone
+	latency time.Duration
+}
+
+var zone zoneConfig
+
+// zoneFromEnv reads the settings of the given zone from the ZONE_COLORS and ZONE_LATENCY
+// environment variables, e.g. ZONE_COLORS=us-east-1a:blue,us-east-1b:green and
+// ZONE_LATENCY=us-east-1b:200ms
+func zoneFromEnv(name string) (zoneConfig, error) {
+	var z zoneConfig
+	if name == "" {
+		return z, nil
+	}
+	zoneColors, err := config.ParsePairs(os.Getenv("ZONE_COLORS"))
+	if err != nil {
+		return z, fmt.Errorf("invalid ZONE_COLORS value: %v", err)
+	}
+	for _, p := range zoneColors {
+		if p.Key == name {
+			if !validColorName(p.Value) {
+				return z, fmt.Errorf("invalid ZONE_COLORS color %q for zone %s", p.Value, p.Key)
+			}
+			z.color = p.Value
+		}
+	}
+	zoneLatencies, err := config.ParsePairs(os.Getenv("ZONE_LATENCY"))
+	if err != nil {
+		return z, fmt.Errorf("invalid ZONE_LATENCY value: %v", err)
+	}
+	for _, p := range zoneLatencies {
+		if p.Key == name {
+			if z.latency, err = time.ParseDuration(p.Value); err != nil || z.latency < 0 {
+				return z, fmt.Errorf("invalid ZONE_LATENCY latency %q for zone %s", p.Value, p.Key)
+			}
+		}
+	}
+	return z, nil
+}